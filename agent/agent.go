@@ -0,0 +1,407 @@
+// Runs conversations with an LLM that can use the tools of MCP servers.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+// Used when the host doesn't give a logger.
+var discardLogger = log.New(io.Discard, "", 0)
+
+// The logger given by the host, or one that discards everything if nil.
+func orDiscard(logger *log.Logger) *log.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
+
+const LLM_CALL_TIMEOUT = 10 * time.Minute
+
+const TOOL_CALL_TIMEOUT = 20 * time.Minute
+
+var ErrSessionBusy = errors.New("the session is still running")
+
+type EventType string
+
+var EVENT_TYPES = struct {
+	Delta      EventType
+	Message    EventType
+	ToolStart  EventType
+	ToolResult EventType
+	Usage      EventType
+	Compaction EventType
+	Error      EventType
+}{
+	Delta:      "delta",
+	Message:    "message",
+	ToolStart:  "tool_start",
+	ToolResult: "tool_result",
+	Usage:      "usage",
+	Compaction: "compaction",
+	Error:      "error",
+}
+
+// Something that happened while running a session.
+// Only the fields relevant to its `Type` are set.
+type Event struct {
+	Type EventType
+	// A piece (text or tool input) of the response being streamed.
+	Delta llm.StreamEvent
+	// A message the session added to the conversation, either a response or tool results.
+	Message llm.Message
+
+	// The tool_use block of the tool being called.
+	ToolUse llm.Block
+	// The tool being called, it has no `Client` when the LLM asked for an unknown tool.
+	Tool         MCPTool
+	ToolResponse ToolResponse
+	// The tool_result block sent back to the LLM.
+	ToolResult llm.Block
+
+	Usage      llm.Usage
+	Model      string
+	StopReason llm.StopReason
+
+	// The conversation was compacted, it must be read again from `Session.Messages`.
+	Compaction CompactionResult
+
+	Err error
+}
+
+// A conversation with an LLM, which calls the tools it asks for
+// until it gives a final answer.
+type Session struct {
+	Provider llm.Provider
+	Tools    *ToolRegistry
+	// Decides which tools need the user's approval, every tool is allowed if nil.
+	Policies *ToolPolicies
+	// Asks the user about tools with an `ask` policy, they're denied if nil.
+	Approve ToolApprover
+	// Max amount of responses requested on a single run, 0 means no limit.
+	MaxTurns   int
+	Compaction CompactionConfig
+	// Goroutines of the session are added to it (if not nil),
+	// so the host can wait for them before exiting.
+	WaitGroup *sync.WaitGroup
+	// Nothing is logged if nil.
+	Logger *log.Logger
+
+	mutex    sync.Mutex
+	defaults llm.Request
+	messages []llm.Message
+	running  bool
+	// Cancels the current run.
+	cancelRun context.CancelCauseFunc
+}
+
+// `defaults` is used as the base of every request, its messages and tools are ignored.
+func NewSession(provider llm.Provider, tools *ToolRegistry, defaults llm.Request) *Session {
+	return &Session{
+		Provider: provider,
+		Tools:    tools,
+		defaults: defaults,
+	}
+}
+
+// Changes the base of the following requests, like the model or system prompt.
+func (session *Session) SetDefaults(defaults llm.Request) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.defaults = defaults
+}
+
+func (session *Session) Defaults() llm.Request {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.defaults
+}
+
+// A copy of the conversation so far.
+func (session *Session) Messages() []llm.Message {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return slices.Clone(session.messages)
+}
+
+// Replaces the whole conversation, like when resuming a saved one.
+func (session *Session) SetMessages(messages []llm.Message) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.running {
+		return ErrSessionBusy
+	}
+	session.messages = slices.Clone(messages)
+	return nil
+}
+
+// Adds messages to the conversation without requesting a response.
+func (session *Session) Append(messages ...llm.Message) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.running {
+		return ErrSessionBusy
+	}
+	session.messages = append(session.messages, messages...)
+	return nil
+}
+
+func (session *Session) logger() *log.Logger {
+	return orDiscard(session.Logger)
+}
+
+func (session *Session) Running() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.running
+}
+
+// Sends a user message with `text`, see `Run`.
+func (session *Session) Send(ctx context.Context, text string) (<-chan Event, error) {
+	return session.Run(ctx, llm.NewUserMessage(llm.NewTextBlock(text)))
+}
+
+// Adds `messages` to the conversation and requests responses until the LLM stops using tools.
+//
+// Events are sent on the returned channel, which is closed once the run finishes.
+// The channel must be read until then, or `ctx` cancelled.
+func (session *Session) Run(ctx context.Context, messages ...llm.Message) (<-chan Event, error) {
+	// Events are still sent after `Cancel`, only the run itself stops.
+	runCtx, err := session.start(ctx, messages...)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	if session.WaitGroup != nil {
+		session.WaitGroup.Add(1)
+	}
+	go func() {
+		if session.WaitGroup != nil {
+			defer session.WaitGroup.Done()
+		}
+		defer close(events)
+		defer session.finish()
+
+		emit := func(event Event) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		}
+		session.run(runCtx, emit)
+	}()
+
+	return events, nil
+}
+
+// Marks the session as running, adding `messages` to the conversation.
+// Returns the context of the run, which `Cancel` cancels.
+func (session *Session) start(ctx context.Context, messages ...llm.Message) (context.Context, error) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.running {
+		return nil, ErrSessionBusy
+	}
+
+	session.running = true
+	session.messages = append(session.messages, messages...)
+	runCtx, cancelRun := context.WithCancelCause(ctx)
+	session.cancelRun = cancelRun
+	return runCtx, nil
+}
+
+func (session *Session) finish() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.running = false
+	session.cancelRun(nil)
+	session.cancelRun = nil
+}
+
+// Stops the current run (or compaction), cancelling the request to the LLM or the tool calls in progress.
+// Returns false if the session isn't running.
+func (session *Session) Cancel() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.cancelRun == nil {
+		return false
+	}
+
+	session.logger().Println("Cancelling the current run...")
+	session.cancelRun(ErrCancelled)
+	return true
+}
+
+func (session *Session) run(ctx context.Context, emit func(Event)) {
+	for turn := 1; ; turn++ {
+		if session.MaxTurns > 0 && turn > session.MaxTurns {
+			emit(Event{Type: EVENT_TYPES.Error, Err: fmt.Errorf("reached the maximum of %d turns", session.MaxTurns)})
+			return
+		}
+		if ctx.Err() != nil {
+			emit(Event{Type: EVENT_TYPES.Error, Err: context.Cause(ctx)})
+			return
+		}
+
+		session.compactIfNeeded(ctx, emit)
+		request := session.nextRequest()
+		if turn > 1 && forcesToolUse(request.ToolChoice) {
+			// Forcing a tool after the results of the previous ones would never let the LLM answer.
+			request.ToolChoice = "auto"
+		}
+
+		callCtx, cancelCall := context.WithTimeout(ctx, LLM_CALL_TIMEOUT)
+		session.logger().Printf("Calling %s for response (turn %d)...", session.Provider.Name(), turn)
+		response, err := session.Provider.Stream(callCtx, request, func(event llm.StreamEvent) {
+			emit(Event{Type: EVENT_TYPES.Delta, Delta: event})
+		})
+		cancelCall()
+		if err != nil && ctx.Err() != nil {
+			err = context.Cause(ctx)
+		}
+		if err != nil {
+			session.logger().Printf("Failed to get response from %s: %s", session.Provider.Name(), err)
+			emit(Event{Type: EVENT_TYPES.Error, Err: err})
+			return
+		}
+		session.logger().Printf("%s responded correctly!", session.Provider.Name())
+
+		session.append(response.Message)
+		emit(Event{Type: EVENT_TYPES.Message, Message: response.Message})
+		emit(Event{
+			Type:       EVENT_TYPES.Usage,
+			Usage:      response.Usage,
+			Model:      response.Model,
+			StopReason: response.StopReason,
+		})
+
+		if response.StopReason != llm.StopReasonToolUse {
+			return
+		}
+
+		results := session.callTools(ctx, response.Message, emit)
+		session.append(results)
+		emit(Event{Type: EVENT_TYPES.Message, Message: results})
+	}
+}
+
+// Whether a tool choice makes the LLM use a tool, `any` or the name of a tool.
+func forcesToolUse(toolChoice string) bool {
+	return toolChoice != "" && toolChoice != "auto" && toolChoice != "none"
+}
+
+func (session *Session) append(msg llm.Message) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.messages = append(session.messages, msg)
+}
+
+// Calls every tool the response asked for concurrently.
+// Returns the message with their results, one tool_result per tool_use in the same order.
+func (session *Session) callTools(ctx context.Context, response llm.Message, emit func(Event)) llm.Message {
+	toolUses := []llm.Block{}
+	for _, block := range response.Content {
+		if block.Type == llm.BlockToolUse {
+			toolUses = append(toolUses, block)
+		}
+	}
+
+	responses := make([]ToolResponse, len(toolUses))
+	wg := sync.WaitGroup{}
+	for i, toolUse := range toolUses {
+		tool, found := session.Tools.Find(toolUse.ToolName)
+		emit(Event{Type: EVENT_TYPES.ToolStart, ToolUse: toolUse, Tool: tool})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if found {
+				responses[i] = session.callTool(ctx, tool, toolUse)
+			} else {
+				session.logger().Println("The LLM tried to use", toolUse.ToolName, ". But this tool doesn't exist!")
+				responses[i] = NewToolErrorResponse(toolUse.ToolUseId, "Error: the tool `%s` doesn't exist!", toolUse.ToolName)
+			}
+
+			if ctx.Err() != nil && responses[i].IsError {
+				responses[i] = NewToolErrorResponse(toolUse.ToolUseId, "Error: the tool call was %s", context.Cause(ctx))
+			}
+
+			toolResult, _ := ToolResultBlocks(responses[i])
+			emit(Event{
+				Type:         EVENT_TYPES.ToolResult,
+				ToolUse:      toolUse,
+				Tool:         tool,
+				ToolResponse: responses[i],
+				ToolResult:   toolResult,
+			})
+		}()
+	}
+	wg.Wait()
+
+	// The LLM expects exactly one tool_result per tool_use, all inside the same message.
+	blocks := make([]llm.Block, 0, len(toolUses))
+	// Blocks that can't live inside a tool_result must come after all of them.
+	extraBlocks := []llm.Block{}
+	for _, response := range responses {
+		result, extra := ToolResultBlocks(response)
+		blocks = append(blocks, result)
+		extraBlocks = append(extraBlocks, extra...)
+	}
+	return llm.NewUserMessage(append(blocks, extraBlocks...)...)
+}
+
+// Calls a tool only if its policy (or the user) allows it.
+func (session *Session) callTool(ctx context.Context, tool MCPTool, toolUse llm.Block) ToolResponse {
+	policy := TOOL_POLICIES.Allow
+	if session.Policies != nil {
+		policy = session.Policies.Policy(tool)
+	}
+
+	if policy == TOOL_POLICIES.Ask {
+		decision := TOOL_DECISIONS.Deny
+		if session.Approve != nil {
+			decision = session.Approve(ctx, toolUse, tool)
+		}
+		session.logger().Printf("User decision for tool `%s`: %s", toolUse.ToolName, decision)
+
+		switch decision {
+		case TOOL_DECISIONS.AllowAlways:
+			session.Policies.AlwaysAllow(tool)
+			policy = TOOL_POLICIES.Allow
+		case TOOL_DECISIONS.AllowOnce:
+			policy = TOOL_POLICIES.Allow
+		default:
+			return NewToolErrorResponse(toolUse.ToolUseId, "Error: the user denied the use of the tool `%s`", toolUse.ToolName)
+		}
+	}
+
+	if policy == TOOL_POLICIES.Deny {
+		session.logger().Printf("Tool `%s` of `%s` is denied by policy", tool.Name, tool.ServerName)
+		return NewToolErrorResponse(toolUse.ToolUseId, "Error: the tool `%s` is not allowed by the user's policies", toolUse.ToolName)
+	}
+
+	response := CallTool(ctx, tool, toolUse, session.logger())
+	if !response.ConnectionLost() || !tool.Idempotent() {
+		return response
+	}
+
+	// Calling it again has no additional effect, so it's retried once the server is back.
+	session.logger().Printf("Lost the connection to `%s`, retrying `%s` once it reconnects", tool.ServerName, tool.Name)
+	waitCtx, cancelWait := context.WithTimeout(ctx, TOOL_RECONNECTION_TIMEOUT)
+	defer cancelWait()
+	reconnected, ok := session.Tools.WaitForReconnection(waitCtx, tool)
+	if !ok {
+		return response
+	}
+	return CallTool(ctx, reconnected, toolUse, session.logger())
+}
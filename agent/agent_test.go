@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+// Replies with the given responses in order.
+type fakeProvider struct {
+	responses []llm.Response
+	requests  []llm.Request
+}
+
+func (provider *fakeProvider) Name() string {
+	return "Fake"
+}
+
+func (provider *fakeProvider) Stream(ctx context.Context, request llm.Request, onEvent func(llm.StreamEvent)) (*llm.Response, error) {
+	provider.requests = append(provider.requests, request)
+	response := provider.responses[0]
+	provider.responses = provider.responses[1:]
+	for i, block := range response.Message.Content {
+		start := block
+		if onEvent != nil {
+			onEvent(llm.StreamEvent{Index: i, Start: &start})
+		}
+	}
+	return &response, nil
+}
+
+func Test_SessionRun(t *testing.T) {
+	provider := &fakeProvider{responses: []llm.Response{
+		{
+			Message: llm.NewAssistantMessage(
+				llm.NewToolUseBlock("toolu_01", "gh__search", json.RawMessage(`{"query":"mcp"}`)),
+				llm.NewToolUseBlock("toolu_02", "gh__issues", nil),
+			),
+			StopReason: llm.StopReasonToolUse,
+		},
+		{
+			Message:    llm.NewAssistantMessage(llm.NewTextBlock("Nothing found.")),
+			StopReason: llm.StopReasonEndTurn,
+			Usage:      llm.Usage{InputTokens: 20, OutputTokens: 3},
+		},
+	}}
+	session := NewSession(provider, NewToolRegistry(), llm.Request{Model: "fake-1", MaxTokens: 100})
+
+	events, err := session.Send(context.Background(), "Search mcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Send(context.Background(), "Again"); err != ErrSessionBusy {
+		t.Errorf("Expected the session to be busy, got %v", err)
+	}
+
+	counts := map[EventType]int{}
+	toolResults := map[string]bool{}
+	for event := range events {
+		counts[event.Type]++
+		if event.Type == EVENT_TYPES.ToolResult {
+			toolResults[event.ToolUse.ToolUseId] = event.ToolResponse.IsError
+		}
+	}
+
+	if counts[EVENT_TYPES.Message] != 3 || counts[EVENT_TYPES.Usage] != 2 || counts[EVENT_TYPES.Delta] != 3 {
+		t.Errorf("Unexpected amount of events: %v", counts)
+	}
+	if counts[EVENT_TYPES.ToolStart] != 2 || len(toolResults) != 2 || !toolResults["toolu_01"] || !toolResults["toolu_02"] {
+		t.Errorf("Unknown tools should be reported as errors: %v", toolResults)
+	}
+
+	messages := session.Messages()
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 messages but got %d", len(messages))
+	}
+	results := messages[2].Content
+	if len(results) != 2 || results[0].ToolUseId != "toolu_01" || results[1].ToolUseId != "toolu_02" {
+		t.Errorf("Tool results should keep the order of the tool uses, got %#v", results)
+	}
+	if request := provider.requests[1]; request.Model != "fake-1" || len(request.Messages) != 3 {
+		t.Errorf("Requests should use the defaults and the whole conversation, got %+v", request)
+	}
+	if session.Running() {
+		t.Error("The session should stop running once its events are closed!")
+	}
+}
+
+func Test_SessionForcesToolChoiceOnce(t *testing.T) {
+	provider := &fakeProvider{responses: []llm.Response{
+		{
+			Message:    llm.NewAssistantMessage(llm.NewToolUseBlock("toolu_01", "gh__search", nil)),
+			StopReason: llm.StopReasonToolUse,
+		},
+		{
+			Message:    llm.NewAssistantMessage(llm.NewTextBlock("Nothing found.")),
+			StopReason: llm.StopReasonEndTurn,
+		},
+	}}
+	session := NewSession(provider, NewToolRegistry(), llm.Request{ToolChoice: "gh__search"})
+
+	events, err := session.Send(context.Background(), "Search mcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+
+	if len(provider.requests) != 2 || provider.requests[0].ToolChoice != "gh__search" || provider.requests[1].ToolChoice != "auto" {
+		t.Errorf("Expected the tool to be forced only on the first request, got %+v", provider.requests)
+	}
+	if session.Defaults().ToolChoice != "gh__search" {
+		t.Error("Expected the next run to force the tool again")
+	}
+}
+
+func Test_SessionMaxTurns(t *testing.T) {
+	provider := &fakeProvider{responses: []llm.Response{{
+		Message:    llm.NewAssistantMessage(llm.NewToolUseBlock("toolu_01", "gh__search", nil)),
+		StopReason: llm.StopReasonToolUse,
+	}}}
+	session := NewSession(provider, NewToolRegistry(), llm.Request{})
+	session.MaxTurns = 1
+
+	events, err := session.Send(context.Background(), "Search mcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lastErr error
+	for event := range events {
+		if event.Type == EVENT_TYPES.Error {
+			lastErr = event.Err
+		}
+	}
+	if lastErr == nil {
+		t.Error("Expected an error after reaching the max turns!")
+	}
+}
+
+// Blocks every request until it's cancelled.
+type blockingProvider struct {
+	started chan struct{}
+}
+
+func (provider *blockingProvider) Name() string {
+	return "Blocking"
+}
+
+func (provider *blockingProvider) Stream(ctx context.Context, request llm.Request, onEvent func(llm.StreamEvent)) (*llm.Response, error) {
+	close(provider.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func Test_SessionCancel(t *testing.T) {
+	provider := &blockingProvider{started: make(chan struct{})}
+	session := NewSession(provider, NewToolRegistry(), llm.Request{})
+	if session.Cancel() {
+		t.Error("A session that isn't running can't be cancelled!")
+	}
+
+	events, err := session.Send(context.Background(), "Wait forever")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-provider.started
+	if !session.Cancel() {
+		t.Error("Expected the running session to be cancelled!")
+	}
+
+	var lastErr error
+	for event := range events {
+		if event.Type == EVENT_TYPES.Error {
+			lastErr = event.Err
+		}
+	}
+	if !errors.Is(lastErr, ErrCancelled) {
+		t.Errorf("Expected a cancellation error, got %v", lastErr)
+	}
+	if messages := session.Messages(); len(messages) != 1 {
+		t.Errorf("A cancelled response shouldn't be added, got %d messages", len(messages))
+	}
+}
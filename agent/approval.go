@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+// Whether a tool can be called without asking the user.
+type ToolPolicy string
+
+var TOOL_POLICIES = struct {
+	Allow ToolPolicy
+	Deny  ToolPolicy
+	Ask   ToolPolicy
+}{
+	Allow: "allow",
+	Deny:  "deny",
+	Ask:   "ask",
+}
+
+// Applies a policy to every tool matching both globs (see `path.Match`).
+type ToolPolicyRule struct {
+	// Matches the server name, empty matches every server.
+	Server string
+	// Matches the name the server knows the tool by, empty matches every tool.
+	Tool   string
+	Policy ToolPolicy
+}
+
+func (rule ToolPolicyRule) matches(tool MCPTool) bool {
+	serverMatch := rule.Server == ""
+	if !serverMatch {
+		serverMatch, _ = path.Match(rule.Server, tool.ServerName)
+	}
+	toolMatch := rule.Tool == ""
+	if !toolMatch {
+		toolMatch, _ = path.Match(rule.Tool, tool.Name)
+	}
+	return serverMatch && toolMatch
+}
+
+// The user's answer when asked to approve a tool call.
+type ToolDecision string
+
+var TOOL_DECISIONS = struct {
+	AllowOnce   ToolDecision
+	AllowAlways ToolDecision
+	Deny        ToolDecision
+}{
+	AllowOnce:   "allow_once",
+	AllowAlways: "allow_always",
+	Deny:        "deny",
+}
+
+// Asks the user whether the tool requested on `toolUse` can be called.
+// It must return `TOOL_DECISIONS.Deny` if `ctx` is cancelled before the user answers.
+type ToolApprover func(ctx context.Context, toolUse llm.Block, tool MCPTool) ToolDecision
+
+// Decides which tools can be called, safe to use from many goroutines.
+type ToolPolicies struct {
+	// Used when no rule matches a tool.
+	Default ToolPolicy
+	// Checked in order, the first one matching a tool is used.
+	Rules []ToolPolicyRule
+
+	mutex sync.RWMutex
+	// Exposed names of the tools the user chose to always allow.
+	alwaysAllowed map[string]bool
+}
+
+// Validates the policies, `defaultPolicy` is `ask` if empty.
+func NewToolPolicies(defaultPolicy ToolPolicy, rules []ToolPolicyRule) (*ToolPolicies, error) {
+	if defaultPolicy == "" {
+		defaultPolicy = TOOL_POLICIES.Ask
+	}
+	if !isToolPolicy(defaultPolicy) {
+		return nil, fmt.Errorf("unknown tool policy `%s`, use `allow`, `deny` or `ask`", defaultPolicy)
+	}
+
+	for i, rule := range rules {
+		if !isToolPolicy(rule.Policy) {
+			return nil, fmt.Errorf("unknown policy `%s` on tool policy %d, use `allow`, `deny` or `ask`", rule.Policy, i+1)
+		}
+		if _, err := path.Match(rule.Server, ""); err != nil {
+			return nil, fmt.Errorf("invalid server glob `%s` on tool policy %d: %w", rule.Server, i+1, err)
+		}
+		if _, err := path.Match(rule.Tool, ""); err != nil {
+			return nil, fmt.Errorf("invalid tool glob `%s` on tool policy %d: %w", rule.Tool, i+1, err)
+		}
+	}
+
+	return &ToolPolicies{
+		Default:       defaultPolicy,
+		Rules:         rules,
+		alwaysAllowed: make(map[string]bool),
+	}, nil
+}
+
+func isToolPolicy(policy ToolPolicy) bool {
+	return policy == TOOL_POLICIES.Allow || policy == TOOL_POLICIES.Deny || policy == TOOL_POLICIES.Ask
+}
+
+// The policy that applies to `tool`.
+func (policies *ToolPolicies) Policy(tool MCPTool) ToolPolicy {
+	policy := policies.Default
+	for _, rule := range policies.Rules {
+		if rule.matches(tool) {
+			policy = rule.Policy
+			break
+		}
+	}
+
+	if policy == TOOL_POLICIES.Ask {
+		policies.mutex.RLock()
+		defer policies.mutex.RUnlock()
+		if policies.alwaysAllowed[tool.ExposedName] {
+			return TOOL_POLICIES.Allow
+		}
+	}
+	return policy
+}
+
+// Stops asking the user about `tool` until the host exits.
+func (policies *ToolPolicies) AlwaysAllow(tool MCPTool) {
+	policies.mutex.Lock()
+	defer policies.mutex.Unlock()
+	if policies.alwaysAllowed == nil {
+		policies.alwaysAllowed = make(map[string]bool)
+	}
+	policies.alwaysAllowed[tool.ExposedName] = true
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+func Test_ToolPolicies(t *testing.T) {
+	policies, err := NewToolPolicies("", []ToolPolicyRule{
+		{Server: "Github*", Tool: "get_*", Policy: TOOL_POLICIES.Allow},
+		{Server: "Github*", Policy: TOOL_POLICIES.Ask},
+		{Tool: "delete_*", Policy: TOOL_POLICIES.Deny},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		server   string
+		tool     string
+		expected ToolPolicy
+	}{
+		{"Github MCP", "get_issue", TOOL_POLICIES.Allow},
+		{"Github MCP", "delete_repo", TOOL_POLICIES.Ask},
+		{"Files", "delete_file", TOOL_POLICIES.Deny},
+		{"Files", "read_file", TOOL_POLICIES.Ask},
+	}
+	for _, c := range cases {
+		tool := MCPTool{ServerName: c.server, Name: c.tool, ExposedName: c.server + "__" + c.tool}
+		if policy := policies.Policy(tool); policy != c.expected {
+			t.Errorf("Expected `%s` for `%s` of `%s` but got `%s`", c.expected, c.tool, c.server, policy)
+		}
+	}
+
+	tool := MCPTool{ServerName: "Files", Name: "read_file", ExposedName: "files__read_file"}
+	policies.AlwaysAllow(tool)
+	if policy := policies.Policy(tool); policy != TOOL_POLICIES.Allow {
+		t.Errorf("Expected an always allowed tool to be allowed, got `%s`", policy)
+	}
+
+	if _, err := NewToolPolicies("maybe", nil); err == nil {
+		t.Error("Expected an error with an unknown default policy!")
+	}
+	if _, err := NewToolPolicies("", []ToolPolicyRule{{Tool: "[", Policy: TOOL_POLICIES.Deny}}); err == nil {
+		t.Error("Expected an error with an invalid glob!")
+	}
+}
+
+func Test_SessionToolApproval(t *testing.T) {
+	provider := &fakeProvider{responses: []llm.Response{
+		{
+			Message: llm.NewAssistantMessage(
+				llm.NewToolUseBlock("toolu_01", "gh__delete_repo", nil),
+				llm.NewToolUseBlock("toolu_02", "gh__merge_pr", nil),
+			),
+			StopReason: llm.StopReasonToolUse,
+		},
+		{
+			Message:    llm.NewAssistantMessage(llm.NewTextBlock("I can't do that.")),
+			StopReason: llm.StopReasonEndTurn,
+		},
+	}}
+	tools := NewToolRegistry()
+	tools.SetServerTools("Github", []MCPTool{
+		{ServerName: "Github", Name: "delete_repo", ExposedName: "gh__delete_repo"},
+		{ServerName: "Github", Name: "merge_pr", ExposedName: "gh__merge_pr"},
+	})
+	session := NewSession(provider, tools, llm.Request{})
+	session.Policies, _ = NewToolPolicies(TOOL_POLICIES.Ask, []ToolPolicyRule{
+		{Tool: "delete_*", Policy: TOOL_POLICIES.Deny},
+	})
+	asked := []string{}
+	session.Approve = func(ctx context.Context, toolUse llm.Block, tool MCPTool) ToolDecision {
+		asked = append(asked, tool.Name)
+		return TOOL_DECISIONS.Deny
+	}
+
+	events, err := session.Send(context.Background(), "Clean up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+
+	if len(asked) != 1 || asked[0] != "merge_pr" {
+		t.Errorf("Only tools with an `ask` policy should be approved by the user, asked for %v", asked)
+	}
+	results := session.Messages()[2].Content
+	if len(results) != 2 || !results[0].IsError || !results[1].IsError {
+		t.Errorf("Denied tools should be reported as errors, got %#v", results)
+	}
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// The reason given to `context.CancelCauseFunc` when the user cancels a run.
+var ErrCancelled = errors.New("cancelled by the user")
+
+const METHOD_NOTIFICATION_CANCELLED = "notifications/cancelled"
+
+// Max time spent telling a server that a request was cancelled.
+const CANCEL_NOTIFICATION_TIMEOUT = 5 * time.Second
+
+// Wraps the transport of a client to send `notifications/cancelled`
+// whenever the context of a request is done before the server responds,
+// since the client doesn't expose the IDs of its requests.
+// Requests also fail once the connection is lost, instead of waiting for a response that never comes.
+type cancellingTransport struct {
+	transport.Interface
+	// Cancelled once the connection is lost, never if nil.
+	connection context.Context
+	// Called when a request shows the connection was lost.
+	loseConnection context.CancelCauseFunc
+	// Nothing is logged if nil.
+	logger *log.Logger
+}
+
+func (trans cancellingTransport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	if trans.connection != nil {
+		var cancelRequest context.CancelCauseFunc
+		ctx, cancelRequest = context.WithCancelCause(ctx)
+		defer cancelRequest(nil)
+		stop := context.AfterFunc(trans.connection, func() {
+			cancelRequest(context.Cause(trans.connection))
+		})
+		defer stop()
+	}
+
+	response, err := trans.Interface.SendRequest(ctx, request)
+	if err != nil && errors.Is(err, transport.ErrSessionTerminated) && trans.loseConnection != nil {
+		trans.loseConnection(err)
+	}
+	if err != nil && trans.connection != nil && trans.connection.Err() != nil {
+		return response, fmt.Errorf("%w: %w", ErrConnectionLost, context.Cause(trans.connection))
+	}
+	// The initialize request must never be cancelled.
+	if err == nil || ctx.Err() == nil || request.Method == string(mcp.MethodInitialize) {
+		return response, err
+	}
+
+	reason := context.Cause(ctx).Error()
+	logger := orDiscard(trans.logger)
+	logger.Printf("Cancelling request %v (%s): %s", request.ID, request.Method, reason)
+	notifyCtx, cancelNotify := context.WithTimeout(context.Background(), CANCEL_NOTIFICATION_TIMEOUT)
+	defer cancelNotify()
+	notifyErr := trans.Interface.SendNotification(notifyCtx, mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: METHOD_NOTIFICATION_CANCELLED,
+			Params: mcp.NotificationParams{
+				AdditionalFields: map[string]any{
+					"requestId": request.ID,
+					"reason":    reason,
+				},
+			},
+		},
+	})
+	if notifyErr != nil {
+		logger.Printf("Failed to notify the cancellation of request %v: %s", request.ID, notifyErr)
+	}
+	return response, err
+}
+
+// The client checks for these optional methods, so they're forwarded when the wrapped transport has them.
+
+func (trans cancellingTransport) SetRequestHandler(handler transport.RequestHandler) {
+	if bidirectional, ok := trans.Interface.(transport.BidirectionalInterface); ok {
+		bidirectional.SetRequestHandler(func(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+			return handler(withSamplingTemperature(ctx, request), request)
+		})
+	}
+}
+
+func (trans cancellingTransport) SetProtocolVersion(version string) {
+	if httpConn, ok := trans.Interface.(transport.HTTPConnection); ok {
+		httpConn.SetProtocolVersion(version)
+	}
+}
+
+func (trans cancellingTransport) SetConnectionLostHandler(handler func(error)) {
+	type connectionLostSetter interface {
+		SetConnectionLostHandler(func(error))
+	}
+	if setter, ok := trans.Interface.(connectionLostSetter); ok {
+		setter.SetConnectionLostHandler(handler)
+	}
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Never responds, only records the notifications sent.
+type silentTransport struct {
+	notifications []mcp.JSONRPCNotification
+}
+
+func (trans *silentTransport) Start(ctx context.Context) error {
+	return nil
+}
+
+func (trans *silentTransport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (trans *silentTransport) SendNotification(ctx context.Context, notification mcp.JSONRPCNotification) error {
+	trans.notifications = append(trans.notifications, notification)
+	return nil
+}
+
+func (trans *silentTransport) SetNotificationHandler(handler func(notification mcp.JSONRPCNotification)) {
+}
+
+func (trans *silentTransport) Close() error {
+	return nil
+}
+
+func (trans *silentTransport) GetSessionId() string {
+	return ""
+}
+
+func Test_CancellingTransport(t *testing.T) {
+	inner := &silentTransport{}
+	trans := cancellingTransport{Interface: inner}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(ErrCancelled)
+	_, err := trans.SendRequest(ctx, transport.JSONRPCRequest{ID: mcp.NewRequestId(int64(7)), Method: string(mcp.MethodToolsCall)})
+	if err == nil {
+		t.Fatal("Expected the request to fail!")
+	}
+
+	if len(inner.notifications) != 1 {
+		t.Fatalf("Expected a single notification, got %d", len(inner.notifications))
+	}
+	notification := inner.notifications[0]
+	if notification.Method != METHOD_NOTIFICATION_CANCELLED || notification.Params.AdditionalFields["requestId"] != mcp.NewRequestId(int64(7)) {
+		t.Errorf("Unexpected notification: %#v", notification)
+	}
+
+	_, _ = trans.SendRequest(ctx, transport.JSONRPCRequest{ID: mcp.NewRequestId(int64(0)), Method: string(mcp.MethodInitialize)})
+	if len(inner.notifications) != 1 {
+		t.Error("The initialize request must never be cancelled!")
+	}
+}
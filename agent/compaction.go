@@ -0,0 +1,321 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+const DEFAULT_COMPACTION_THRESHOLD = 150_000
+
+const DEFAULT_COMPACTION_KEEP_MESSAGES = 6
+
+const DEFAULT_MAX_TOOL_RESULT_LENGTH = 4_000
+
+const COMPACTION_PROMPT = `Summarize the conversation so far, it will replace the conversation so it must be enough to continue it.
+Keep the requests of the user, the decisions made, important facts and tool results, and any pending work.
+Answer only with the summary.`
+
+const COMPACTION_SUMMARY_PREFIX = "Summary of the earlier conversation, which was compacted to save context:\n\n"
+
+const COMPACTION_ACKNOWLEDGEMENT = "Understood, I'll continue from this summary."
+
+// Max time counting the tokens of a request before estimating them,
+// so a slow provider doesn't hold every turn.
+const TOKEN_COUNT_TIMEOUT = 10 * time.Second
+
+// Starts the note added to truncated tool results, so they're never truncated again.
+const TRUNCATION_NOTE_PREFIX = "[Truncated to save context"
+
+// Returned when the conversation has nothing that can be summarized or truncated.
+var ErrNothingToCompact = errors.New("the conversation is too short to compact")
+
+// How the conversation shrinks once it gets too long.
+type CompactionConfig struct {
+	// Compacts before a request with more tokens than this.
+	// Uses `DEFAULT_COMPACTION_THRESHOLD` if 0, never compacts automatically if negative.
+	Threshold int64
+	// Min amount of the latest messages kept as they are, `DEFAULT_COMPACTION_KEEP_MESSAGES` if 0.
+	KeepMessages int
+	// Tool results with more characters than this are truncated, `DEFAULT_MAX_TOOL_RESULT_LENGTH` if 0.
+	MaxToolResultLength int
+}
+
+// What a compaction did to the conversation.
+type CompactionResult struct {
+	TokensBefore int64
+	TokensAfter  int64
+	// Messages replaced by a summary.
+	SummarizedMessages int
+	TruncatedResults   int
+}
+
+func (config CompactionConfig) keepMessages() int {
+	if config.KeepMessages <= 0 {
+		return DEFAULT_COMPACTION_KEEP_MESSAGES
+	}
+	return config.KeepMessages
+}
+
+func (config CompactionConfig) maxToolResultLength() int {
+	if config.MaxToolResultLength <= 0 {
+		return DEFAULT_MAX_TOOL_RESULT_LENGTH
+	}
+	return config.MaxToolResultLength
+}
+
+// Counts the tokens of a request, estimating them if the provider can't count them.
+func (session *Session) countTokens(ctx context.Context, request llm.Request) int64 {
+	if counter, ok := session.Provider.(llm.TokenCounter); ok {
+		countCtx, cancelCount := context.WithTimeout(ctx, TOKEN_COUNT_TIMEOUT)
+		tokens, err := counter.CountTokens(countCtx, request)
+		cancelCount()
+		if err == nil {
+			return tokens
+		}
+		session.logger().Printf("Failed to count tokens, estimating them instead: %s", err)
+	}
+	return llm.EstimateTokens(request)
+}
+
+// The request the session would send next.
+func (session *Session) nextRequest() llm.Request {
+	session.mutex.Lock()
+	request := session.defaults
+	request.Messages = cloneMessages(session.messages)
+	session.mutex.Unlock()
+	request.Tools = session.Tools.Definitions()
+	return request
+}
+
+// Tokens the conversation would use on the next request, including the tools and system prompt.
+func (session *Session) CountTokens(ctx context.Context) int64 {
+	return session.countTokens(ctx, session.nextRequest())
+}
+
+// Summarizes the older messages of the conversation and truncates long tool results,
+// even if the conversation is under the threshold.
+func (session *Session) Compact(ctx context.Context) (CompactionResult, error) {
+	runCtx, err := session.start(ctx)
+	if err != nil {
+		return CompactionResult{}, err
+	}
+	defer session.finish()
+
+	return session.compact(runCtx, session.countTokens(runCtx, session.nextRequest()))
+}
+
+// Compacts the conversation if the next request would go over the threshold.
+// Returns false if nothing was done.
+func (session *Session) compactIfNeeded(ctx context.Context, emit func(Event)) bool {
+	threshold := session.Compaction.Threshold
+	if threshold < 0 {
+		return false
+	}
+	if threshold == 0 {
+		threshold = DEFAULT_COMPACTION_THRESHOLD
+	}
+
+	tokens := session.countTokens(ctx, session.nextRequest())
+	if tokens <= threshold {
+		return false
+	}
+
+	session.logger().Printf("The conversation has %d tokens (over %d), compacting it...", tokens, threshold)
+	result, err := session.compact(ctx, tokens)
+	if err != nil {
+		session.logger().Println("Failed to compact the conversation:", err)
+		return false
+	}
+	emit(Event{Type: EVENT_TYPES.Compaction, Compaction: result})
+	return true
+}
+
+func (session *Session) compact(ctx context.Context, tokensBefore int64) (CompactionResult, error) {
+	result := CompactionResult{TokensBefore: tokensBefore}
+	messages := session.Messages()
+	maxLength := session.Compaction.maxToolResultLength()
+
+	split := compactionSplit(messages, session.Compaction.keepMessages())
+	// Summarizing only the previous summary would gain nothing.
+	if split == 2 && isSummary(messages[0]) {
+		split = 0
+	}
+	compacted := []llm.Message{}
+	if split > 0 {
+		older, _ := truncateToolResults(messages[:split], maxLength)
+		summary, err := session.summarize(ctx, older)
+		if err != nil {
+			return result, fmt.Errorf("failed to summarize the conversation: %w", err)
+		}
+		compacted = append(compacted,
+			llm.NewUserMessage(llm.NewTextBlock(COMPACTION_SUMMARY_PREFIX+summary)),
+			llm.NewAssistantMessage(llm.NewTextBlock(COMPACTION_ACKNOWLEDGEMENT)),
+		)
+		result.SummarizedMessages = split
+	}
+
+	kept, truncated := truncateToolResults(messages[split:], maxLength)
+	compacted = append(compacted, kept...)
+	result.TruncatedResults = truncated
+	if result.SummarizedMessages == 0 && result.TruncatedResults == 0 {
+		return result, ErrNothingToCompact
+	}
+
+	// Nothing else changes the conversation while the session is running.
+	session.mutex.Lock()
+	session.messages = compacted
+	session.mutex.Unlock()
+
+	result.TokensAfter = session.countTokens(ctx, session.nextRequest())
+	session.logger().Printf("Compacted the conversation: %+v", result)
+	return result, nil
+}
+
+// Asks the LLM for a summary of `messages`.
+func (session *Session) summarize(ctx context.Context, messages []llm.Message) (string, error) {
+	request := session.Defaults()
+	request.System = COMPACTION_PROMPT
+	request.Messages = append(cloneMessages(messages), llm.NewUserMessage(llm.NewTextBlock("Summarize the conversation so far.")))
+	// The tool uses of the conversation need their definitions, but no more tools must be used.
+	request.Tools = session.Tools.Definitions()
+	request.ToolChoice = "none"
+
+	callCtx, cancelCall := context.WithTimeout(ctx, LLM_CALL_TIMEOUT)
+	defer cancelCall()
+	response, err := session.Provider.Stream(callCtx, request, nil)
+	if err != nil {
+		return "", err
+	}
+
+	summary := strings.Builder{}
+	for _, block := range response.Message.Content {
+		if block.Type == llm.BlockText {
+			summary.WriteString(block.Text)
+		}
+	}
+	if strings.TrimSpace(summary.String()) == "" {
+		return "", errors.New("the summary is empty")
+	}
+	return summary.String(), nil
+}
+
+// Finds where the kept messages start, 0 if there's nothing to summarize.
+// It must be the start of a turn (a user message that isn't only tool results),
+// so every tool use stays on the same side as its result.
+func compactionSplit(messages []llm.Message, keepMessages int) int {
+	for i := len(messages) - keepMessages; i > 0; i-- {
+		if startsTurn(messages[i]) {
+			return i
+		}
+	}
+	return 0
+}
+
+func startsTurn(msg llm.Message) bool {
+	if msg.Role != llm.RoleUser {
+		return false
+	}
+	for _, block := range msg.Content {
+		if block.Type == llm.BlockToolResult {
+			return false
+		}
+	}
+	return true
+}
+
+// Shortens the tool results with more than `maxLength` characters of text,
+// replacing their images and documents with a note.
+// Returns the new messages and the amount of results truncated, `messages` isn't modified.
+func truncateToolResults(messages []llm.Message, maxLength int) ([]llm.Message, int) {
+	truncated := 0
+	result := cloneMessages(messages)
+	for _, msg := range result {
+		for i, block := range msg.Content {
+			if block.Type != llm.BlockToolResult {
+				continue
+			}
+
+			if isTruncated(block) {
+				continue
+			}
+
+			length := 0
+			attachments := 0
+			for _, resultBlock := range block.Content {
+				if resultBlock.Type == llm.BlockText {
+					length += utf8.RuneCountInString(resultBlock.Text)
+				} else {
+					attachments++
+				}
+			}
+			if length <= maxLength && attachments == 0 {
+				continue
+			}
+
+			content := []llm.Block{}
+			remaining := maxLength
+			for _, resultBlock := range block.Content {
+				if resultBlock.Type != llm.BlockText || remaining <= 0 {
+					continue
+				}
+				text := resultBlock.Text
+				textLength := utf8.RuneCountInString(text)
+				if textLength > remaining {
+					text = truncateRunes(text, remaining)
+					textLength = remaining
+				}
+				remaining -= textLength
+				content = append(content, llm.NewTextBlock(text))
+			}
+			note := fmt.Sprintf("%s, removed %d characters", TRUNCATION_NOTE_PREFIX, length-(maxLength-max(remaining, 0)))
+			if attachments > 0 {
+				note += fmt.Sprintf(" and %d images or documents", attachments)
+			}
+			content = append(content, llm.NewTextBlock(note+"]"))
+
+			msg.Content[i].Content = content
+			truncated++
+		}
+	}
+	return result, truncated
+}
+
+// The first `n` characters of `text`, without splitting a multi-byte character.
+func truncateRunes(text string, n int) string {
+	count := 0
+	for i := range text {
+		if count == n {
+			return text[:i]
+		}
+		count++
+	}
+	return text
+}
+
+func isSummary(msg llm.Message) bool {
+	return msg.Role == llm.RoleUser && len(msg.Content) > 0 && strings.HasPrefix(msg.Content[0].Text, COMPACTION_SUMMARY_PREFIX)
+}
+
+func isTruncated(toolResult llm.Block) bool {
+	if len(toolResult.Content) == 0 {
+		return false
+	}
+	last := toolResult.Content[len(toolResult.Content)-1]
+	return last.Type == llm.BlockText && strings.HasPrefix(last.Text, TRUNCATION_NOTE_PREFIX)
+}
+
+// Copies messages deep enough to modify their blocks.
+func cloneMessages(messages []llm.Message) []llm.Message {
+	clone := make([]llm.Message, len(messages))
+	for i, msg := range messages {
+		clone[i] = llm.Message{Role: msg.Role, Content: slices.Clone(msg.Content)}
+	}
+	return clone
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+func longToolResult(id string, length int) llm.Message {
+	return llm.NewUserMessage(llm.NewToolResultBlock(id, []llm.Block{
+		llm.NewTextBlock(strings.Repeat("a", length)),
+		llm.NewImageBlock("image/png", "iVBORw0KGgo="),
+	}, false))
+}
+
+func Test_SessionCompact(t *testing.T) {
+	provider := &fakeProvider{responses: []llm.Response{{
+		Message:    llm.NewAssistantMessage(llm.NewTextBlock("The user searched for mcp.")),
+		StopReason: llm.StopReasonEndTurn,
+	}}}
+	session := NewSession(provider, NewToolRegistry(), llm.Request{Model: "fake-1"})
+	session.Compaction = CompactionConfig{KeepMessages: 3, MaxToolResultLength: 10}
+	session.SetMessages([]llm.Message{
+		llm.NewUserMessage(llm.NewTextBlock("Search mcp")),
+		llm.NewAssistantMessage(llm.NewToolUseBlock("toolu_01", "gh__search", json.RawMessage(`{"query":"mcp"}`))),
+		longToolResult("toolu_01", 100),
+		llm.NewAssistantMessage(llm.NewTextBlock("Found it.")),
+		llm.NewUserMessage(llm.NewTextBlock("Open it")),
+		llm.NewAssistantMessage(llm.NewToolUseBlock("toolu_02", "gh__open", nil)),
+		longToolResult("toolu_02", 100),
+	})
+
+	result, err := session.Compact(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SummarizedMessages != 4 || result.TruncatedResults != 1 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	messages := session.Messages()
+	if len(messages) != 5 || !strings.Contains(messages[0].Content[0].Text, "The user searched for mcp.") {
+		t.Fatalf("Expected the summary and the last turn, got %#v", messages)
+	}
+	if messages[2].Content[0].Text != "Open it" || messages[3].Content[0].ToolUseId != "toolu_02" {
+		t.Errorf("The last turn should be kept with its tool use, got %#v", messages[2:])
+	}
+	toolResult := messages[4].Content[0]
+	if toolResult.ToolUseId != "toolu_02" || len(toolResult.Content) != 2 || toolResult.Content[0].Text != "aaaaaaaaaa" {
+		t.Errorf("Expected the tool result to be truncated, got %#v", toolResult)
+	}
+
+	summaryRequest := provider.requests[0]
+	if summaryRequest.System != COMPACTION_PROMPT || len(summaryRequest.Messages) != 5 || summaryRequest.ToolChoice != "none" {
+		t.Errorf("Unexpected summary request: %+v", summaryRequest)
+	}
+
+	if _, err := session.Compact(context.Background()); err != ErrNothingToCompact {
+		t.Errorf("Truncated results shouldn't be truncated again, got %v", err)
+	}
+}
+
+func Test_CompactionSplit(t *testing.T) {
+	messages := []llm.Message{
+		llm.NewUserMessage(llm.NewTextBlock("Search mcp")),
+		llm.NewAssistantMessage(llm.NewToolUseBlock("toolu_01", "gh__search", nil)),
+		longToolResult("toolu_01", 1),
+		llm.NewAssistantMessage(llm.NewToolUseBlock("toolu_02", "gh__search", nil)),
+		longToolResult("toolu_02", 1),
+	}
+
+	// Only the first message starts a turn, so nothing can be summarized.
+	if split := compactionSplit(messages, 2); split != 0 {
+		t.Errorf("Tool uses can't be split from their results, got %d", split)
+	}
+	if split := compactionSplit(append(messages, llm.NewUserMessage(llm.NewTextBlock("Thanks"))), 1); split != 5 {
+		t.Errorf("Expected to split on the last turn, got %d", split)
+	}
+}
+
+func Test_TruncateToolResultsRunes(t *testing.T) {
+	messages := []llm.Message{llm.NewUserMessage(llm.NewToolResultBlock("toolu_01", []llm.Block{
+		llm.NewTextBlock(strings.Repeat("ñ", 8)),
+	}, false))}
+
+	truncated, count := truncateToolResults(messages, 5)
+	content := truncated[0].Content[0].Content
+	if count != 1 || len(content) != 2 {
+		t.Fatalf("Expected the tool result to be truncated, got %#v", truncated)
+	}
+	if content[0].Text != "ñññññ" {
+		t.Errorf("Expected the text to be cut between characters, got %q", content[0].Text)
+	}
+	if !strings.Contains(content[1].Text, "removed 3 characters") {
+		t.Errorf("Expected the removed characters to be counted, got %q", content[1].Text)
+	}
+}
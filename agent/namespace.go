@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"regexp"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Separates the server prefix from the original MCP tool name.
+const TOOL_NAMESPACE_SEPARATOR = "__"
+
+// Claude only accepts tool names matching `^[a-zA-Z0-9_-]{1,64}$`.
+const MAX_TOOL_NAME_LENGTH = 64
+
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// A tool exposed by an MCP server, as registered on the host.
+type MCPTool struct {
+	Client     *client.Client
+	ServerName string
+	// The name the MCP server knows this tool by.
+	Name string
+	// The namespaced name the LLM knows this tool by.
+	ExposedName string
+	Definition  llm.Tool
+	// Hints of the server about what the tool does.
+	Annotations mcp.ToolAnnotation
+}
+
+// Whether calling the tool again has no additional effect, so a failed call can be retried.
+func (tool MCPTool) Idempotent() bool {
+	hints := tool.Annotations
+	readOnly := hints.ReadOnlyHint != nil && *hints.ReadOnlyHint
+	idempotent := hints.IdempotentHint != nil && *hints.IdempotentHint
+	return readOnly || idempotent
+}
+
+// Obtains the prefix used to namespace all tools of a server.
+// If the config doesn't specify a `Prefix` the server name is used instead.
+func ToolPrefix(config MCPServerConfig) string {
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = config.Name
+	}
+
+	return invalidToolNameChars.ReplaceAllString(prefix, "_")
+}
+
+// Builds the name Claude will see for the tool `toolName` of a server with the given prefix.
+func NamespacedToolName(prefix string, toolName string) string {
+	name := toolName
+	if prefix != "" {
+		name = prefix + TOOL_NAMESPACE_SEPARATOR + toolName
+	}
+
+	name = invalidToolNameChars.ReplaceAllString(name, "_")
+	if len(name) > MAX_TOOL_NAME_LENGTH {
+		name = name[:MAX_TOOL_NAME_LENGTH]
+	}
+	return name
+}
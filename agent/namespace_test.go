@@ -0,0 +1,37 @@
+package agent
+
+import "testing"
+
+func Test_ToolPrefix(t *testing.T) {
+	prefix := ToolPrefix(MCPServerConfig{Name: "Playwright MCP"})
+	if prefix != "Playwright_MCP" {
+		t.Errorf("Expected prefix `Playwright_MCP` but got `%s`", prefix)
+	}
+
+	prefix = ToolPrefix(MCPServerConfig{Name: "Github MCP", Prefix: "gh"})
+	if prefix != "gh" {
+		t.Errorf("Expected prefix `gh` but got `%s`", prefix)
+	}
+}
+
+func Test_NamespacedToolName(t *testing.T) {
+	name := NamespacedToolName("gh", "search")
+	if name != "gh__search" {
+		t.Errorf("Expected `gh__search` but got `%s`", name)
+	}
+
+	name = NamespacedToolName("", "search")
+	if name != "search" {
+		t.Errorf("Expected `search` but got `%s`", name)
+	}
+
+	name = NamespacedToolName("gh", "search.repos")
+	if name != "gh__search_repos" {
+		t.Errorf("Invalid characters should be replaced, got `%s`", name)
+	}
+
+	long := NamespacedToolName("a_very_long_prefix_for_a_server", "and_an_even_longer_tool_name_that_overflows")
+	if len(long) > MAX_TOOL_NAME_LENGTH {
+		t.Errorf("Name `%s` is longer than %d characters", long, MAX_TOOL_NAME_LENGTH)
+	}
+}
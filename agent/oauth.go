@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+const DEFAULT_OAUTH_REDIRECT_PORT = 8085
+
+const DEFAULT_OAUTH_TOKENS_DIR = "tokens"
+
+const OAUTH_CALLBACK_PATH = "/callback"
+
+// Max time waiting for the user to authorize on the browser.
+const OAUTH_AUTHORIZATION_TIMEOUT = 5 * time.Minute
+
+// The `[Servers.OAuth]` section of an HTTP server.
+type OAuthConfig struct {
+	// Authorizes with the MCP OAuth flow (authorization code with PKCE), opening the browser the first time.
+	Enabled bool
+	// Registered dynamically with the server if empty.
+	ClientId string
+	// Env variable with the client secret, only for confidential clients.
+	ClientSecretEnv string
+	Scopes          []string
+	// Port on localhost the browser is redirected to after authorizing, `DEFAULT_OAUTH_REDIRECT_PORT` if 0.
+	RedirectPort int
+	// Discovered from the server if empty.
+	AuthServerMetadataURL string
+	// File caching the tokens, `tokens/<prefix>.json` by default.
+	TokenFile string
+}
+
+// Returned when the user couldn't authorize the host to use a server.
+var ErrAuthorization = errors.New("failed to authorize client")
+
+// Servers connect in parallel but share the redirect port, so only one authorizes at a time.
+var authorizationLock = make(chan struct{}, 1)
+
+// Shows the user where to authorize the host to use an MCP server, when the connection doesn't say how.
+// It prints the URL and tries to open it on the browser.
+func PrintAuthorizationURL(serverName string, authURL string) {
+	fmt.Fprintf(os.Stderr, "Authorize the access to `%s` on your browser:\n%s\n", serverName, authURL)
+	if err := OpenBrowser(authURL); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open the browser:", err)
+	}
+}
+
+// Opens a URL on the default browser of the user.
+func OpenBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
+// What's cached of the authorization with a server.
+type oauthCache struct {
+	// Set when the client was registered dynamically, since the tokens are only valid for it.
+	ClientId     string           `json:"client_id,omitempty"`
+	ClientSecret string           `json:"client_secret,omitempty"`
+	Token        *transport.Token `json:"token,omitempty"`
+}
+
+// Caches the OAuth tokens of a server on a file, so the user only authorizes once.
+type FileTokenStore struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+func (store *FileTokenStore) load() (oauthCache, error) {
+	cache := oauthCache{}
+	contents, err := os.ReadFile(store.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	} else if err != nil {
+		return cache, err
+	}
+	if err := json.Unmarshal(contents, &cache); err != nil {
+		return cache, fmt.Errorf("invalid token file `%s`: %w", store.Path, err)
+	}
+	return cache, nil
+}
+
+func (store *FileTokenStore) save(cache oauthCache) error {
+	if err := os.MkdirAll(filepath.Dir(store.Path), 0700); err != nil {
+		return err
+	}
+	contents, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(store.Path, contents, 0600)
+}
+
+func (store *FileTokenStore) GetToken() (*transport.Token, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	cache, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+	if cache.Token == nil {
+		return nil, errors.New("no token available")
+	}
+	return cache.Token, nil
+}
+
+func (store *FileTokenStore) SaveToken(token *transport.Token) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	cache, _ := store.load()
+	cache.Token = token
+	return store.save(cache)
+}
+
+// Remembers the client registered dynamically, along with its tokens.
+func (store *FileTokenStore) SaveClient(clientId string, clientSecret string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	cache, _ := store.load()
+	cache.ClientId = clientId
+	cache.ClientSecret = clientSecret
+	return store.save(cache)
+}
+
+// The client registered dynamically on a previous authorization, if any.
+func (store *FileTokenStore) Client() (string, string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	cache, err := store.load()
+	return cache.ClientId, cache.ClientSecret, err
+}
+
+func oauthRedirectURI(config OAuthConfig) string {
+	port := config.RedirectPort
+	if port == 0 {
+		port = DEFAULT_OAUTH_REDIRECT_PORT
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d%s", port, OAUTH_CALLBACK_PATH)
+}
+
+// Builds the OAuth config of the transport, along with the store of its tokens.
+func transportOAuthConfig(config MCPServerConfig) (transport.OAuthConfig, *FileTokenStore, error) {
+	oauth := config.OAuth
+	tokenFile := oauth.TokenFile
+	if tokenFile == "" {
+		tokenFile = filepath.Join(DEFAULT_OAUTH_TOKENS_DIR, ToolPrefix(config)+".json")
+	}
+	store := &FileTokenStore{Path: tokenFile}
+
+	clientId, clientSecret := oauth.ClientId, ""
+	if oauth.ClientSecretEnv != "" {
+		secret, found := os.LookupEnv(oauth.ClientSecretEnv)
+		if !found {
+			return transport.OAuthConfig{}, nil, fmt.Errorf("no `%s` env variable found for the OAuth client secret", oauth.ClientSecretEnv)
+		}
+		clientSecret = secret
+	}
+	if clientId == "" {
+		var err error
+		clientId, clientSecret, err = store.Client()
+		if err != nil {
+			return transport.OAuthConfig{}, nil, fmt.Errorf("failed to read the OAuth client: %w", err)
+		}
+	}
+
+	return transport.OAuthConfig{
+		ClientID:              clientId,
+		ClientSecret:          clientSecret,
+		RedirectURI:           oauthRedirectURI(oauth),
+		Scopes:                oauth.Scopes,
+		TokenStore:            store,
+		AuthServerMetadataURL: oauth.AuthServerMetadataURL,
+		PKCEEnabled:           true,
+	}, store, nil
+}
+
+// Runs the authorization code flow with PKCE, the user authorizes on the browser
+// and it's redirected to a local listener with the code, which is exchanged for a token.
+// Waits for any other server being authorized first.
+func authorize(
+	ctx context.Context,
+	config MCPServerConfig,
+	handler *transport.OAuthHandler,
+	store *FileTokenStore,
+	options ConnectOptions,
+) error {
+	logger := orDiscard(options.Logger)
+	select {
+	case authorizationLock <- struct{}{}:
+		defer func() { <-authorizationLock }()
+	case <-ctx.Done():
+		return fmt.Errorf("the authorization wasn't started: %w", ctx.Err())
+	}
+
+	ctx, cancelCtx := context.WithTimeout(ctx, OAUTH_AUTHORIZATION_TIMEOUT)
+	defer cancelCtx()
+
+	if handler.GetClientID() == "" {
+		logger.Printf("Registering OAuth client with `%s`...", config.Name)
+		if err := handler.RegisterClient(ctx, "CLIude"); err != nil {
+			return fmt.Errorf("failed to register OAuth client: %w", err)
+		}
+		if err := store.SaveClient(handler.GetClientID(), handler.GetClientSecret()); err != nil {
+			logger.Println("Failed to save the OAuth client:", err)
+		}
+	}
+
+	codeVerifier, err := client.GenerateCodeVerifier()
+	if err != nil {
+		return err
+	}
+	state, err := client.GenerateState()
+	if err != nil {
+		return err
+	}
+
+	port := config.OAuth.RedirectPort
+	if port == 0 {
+		port = DEFAULT_OAUTH_REDIRECT_PORT
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen for the OAuth redirect: %w", err)
+	}
+	callbacks := make(chan map[string]string, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != OAUTH_CALLBACK_PATH {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		select {
+		case callbacks <- map[string]string{"code": query.Get("code"), "state": query.Get("state"), "error": query.Get("error")}:
+		default:
+		}
+		fmt.Fprintln(w, "Done! You can close this tab and go back to the terminal.")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authURL, err := handler.GetAuthorizationURL(ctx, state, client.GenerateCodeChallenge(codeVerifier))
+	if err != nil {
+		return fmt.Errorf("failed to build the authorization URL: %w", err)
+	}
+	logger.Printf("Waiting for the authorization of `%s`: %s", config.Name, authURL)
+	showAuthorizationURL := options.ShowAuthorizationURL
+	if showAuthorizationURL == nil {
+		showAuthorizationURL = PrintAuthorizationURL
+	}
+	showAuthorizationURL(config.Name, authURL)
+
+	var callback map[string]string
+	select {
+	case callback = <-callbacks:
+	case <-ctx.Done():
+		return fmt.Errorf("the authorization wasn't completed: %w", ctx.Err())
+	}
+	if callback["error"] != "" {
+		return fmt.Errorf("the authorization was denied: %s", callback["error"])
+	}
+
+	if err := handler.ProcessAuthorizationResponse(ctx, callback["code"], callback["state"], codeVerifier); err != nil {
+		return fmt.Errorf("failed to obtain the OAuth token: %w", err)
+	}
+	logger.Printf("Authorized the access to `%s`", config.Name)
+	return nil
+}
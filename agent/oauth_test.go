@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func Test_FileTokenStore(t *testing.T) {
+	store := &FileTokenStore{Path: filepath.Join(t.TempDir(), "tokens", "gh.json")}
+	if _, err := store.GetToken(); err == nil {
+		t.Fatal("Expected an error without a token")
+	}
+
+	if err := store.SaveClient("client", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveToken(&transport.Token{AccessToken: "access", RefreshToken: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := store.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("Unexpected token: %+v", token)
+	}
+	clientId, clientSecret, err := store.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientId != "client" || clientSecret != "secret" {
+		t.Errorf("Unexpected client: `%s` `%s`", clientId, clientSecret)
+	}
+
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the token file to only be readable by the user, got %s", info.Mode().Perm())
+	}
+}
+
+// Serves an MCP server that only accepts `token`.
+func authenticatedServer(token string) http.Handler {
+	mcpServer := server.NewStreamableHTTPServer(server.NewMCPServer("test", "1.0.0"))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mcpServer.ServeHTTP(w, r)
+	})
+}
+
+func Test_ConnectServerHeaders(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", authenticatedServer("secret"))
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	config := MCPServerConfig{Name: "test", Type: MCP_SERVERS_TYPE.Http, URL: httpServer.URL + "/mcp", BearerTokenEnv: "TEST_MCP_TOKEN"}
+	if _, err := ConnectServer(context.Background(), config, ConnectOptions{}); !errors.Is(err, ErrServerStart) {
+		t.Errorf("Expected a start error without the env variable, got %v", err)
+	}
+
+	t.Setenv("TEST_MCP_TOKEN", "secret")
+	mcpServer, err := ConnectServer(context.Background(), config, ConnectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcpServer.Client.Close()
+
+	config.BearerTokenEnv = ""
+	config.Headers = map[string]string{"Authorization": "Bearer secret"}
+	mcpServer, err = ConnectServer(context.Background(), config, ConnectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcpServer.Client.Close()
+}
+
+func Test_ConnectServerOAuth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", authenticatedServer("access"))
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"client_id": "registered"})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "code" || r.Form.Get("client_id") != "registered" || r.Form.Get("code_verifier") == "" {
+			t.Errorf("Unexpected token request: %v", r.Form)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "token_type": "bearer", "expires_in": 3600})
+	})
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	authorizations := 0
+	// Acts as the user authorizing on the browser.
+	options := ConnectOptions{ShowAuthorizationURL: func(serverName string, authURL string) {
+		authorizations++
+		parsed, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := parsed.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "registered" {
+			t.Errorf("Unexpected authorization URL: %s", authURL)
+		}
+		redirect := query.Get("redirect_uri") + "?" + url.Values{"code": {"code"}, "state": {query.Get("state")}}.Encode()
+		response, err := http.Get(redirect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}}
+
+	config := MCPServerConfig{
+		Name: "test",
+		Type: MCP_SERVERS_TYPE.Http,
+		URL:  httpServer.URL + "/mcp",
+		OAuth: OAuthConfig{
+			Enabled:      true,
+			RedirectPort: port,
+			TokenFile:    filepath.Join(t.TempDir(), "test.json"),
+		},
+	}
+	mcpServer, err := ConnectServer(context.Background(), config, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcpServer.Client.Close()
+
+	// The cached token is used afterwards.
+	mcpServer, err = ConnectServer(context.Background(), config, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcpServer.Client.Close()
+	if authorizations != 1 {
+		t.Errorf("Expected the user to authorize once, got %d", authorizations)
+	}
+
+	// Servers connecting at the same time take turns on the redirect port.
+	wg := sync.WaitGroup{}
+	for _, name := range []string{"first", "second"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config := config
+			config.Name = name
+			config.OAuth.TokenFile = filepath.Join(t.TempDir(), name+".json")
+			mcpServer, err := ConnectServer(context.Background(), config, options)
+			if err != nil {
+				t.Errorf("Failed to authorize `%s`: %s", name, err)
+				return
+			}
+			mcpServer.Client.Close()
+		}()
+	}
+	wg.Wait()
+	if authorizations != 3 {
+		t.Errorf("Expected the user to authorize every server, got %d", authorizations)
+	}
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+type RestartMode string
+
+var RESTART_MODES = struct {
+	// Restarts the server whenever the connection is lost.
+	Always RestartMode
+	// Restarts the server only if it crashed or the connection failed, not if it exited cleanly.
+	OnFailure RestartMode
+	Never     RestartMode
+}{
+	Always:    "always",
+	OnFailure: "on-failure",
+	Never:     "never",
+}
+
+// Restarts of a server before giving up, when its policy doesn't say.
+const DEFAULT_MAX_RESTARTS = 5
+
+// Max time a tool call waits for its server to reconnect before retrying.
+const TOOL_RECONNECTION_TIMEOUT = 1 * time.Minute
+
+// What happens when a server crashes or its connection is lost.
+type RestartPolicy struct {
+	// Defaults to `on-failure`.
+	Mode RestartMode
+	// Defaults to `DEFAULT_MAX_RESTARTS`, negative to restart it forever.
+	MaxRestarts int
+}
+
+func (policy RestartPolicy) Validate() error {
+	switch policy.Mode {
+	case "", RESTART_MODES.Always, RESTART_MODES.OnFailure, RESTART_MODES.Never:
+		return nil
+	default:
+		return fmt.Errorf(
+			"unknown mode `%s`, it must be one of `%s`, `%s` or `%s`",
+			policy.Mode,
+			RESTART_MODES.Always,
+			RESTART_MODES.OnFailure,
+			RESTART_MODES.Never,
+		)
+	}
+}
+
+// Whether a server that was already restarted `restarts` times must be restarted again.
+// `failed` tells if it crashed or the connection failed, instead of exiting cleanly.
+func (policy RestartPolicy) ShouldRestart(failed bool, restarts int) bool {
+	maxRestarts := policy.MaxRestarts
+	if maxRestarts == 0 {
+		maxRestarts = DEFAULT_MAX_RESTARTS
+	}
+	if maxRestarts > 0 && restarts >= maxRestarts {
+		return false
+	}
+
+	switch policy.Mode {
+	case RESTART_MODES.Always:
+		return true
+	case RESTART_MODES.Never:
+		return false
+	default:
+		return failed
+	}
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Set on the test binary when it runs as a stdio server.
+const TEST_STDIO_SERVER_ENV = "AGENT_TEST_STDIO_SERVER"
+
+// The test binary runs itself as a stdio server whose `crash` tool exits the process.
+func TestMain(m *testing.M) {
+	if os.Getenv(TEST_STDIO_SERVER_ENV) == "" {
+		os.Exit(m.Run())
+	}
+
+	mcpServer := server.NewMCPServer("crashy", "1.0.0")
+	mcpServer.AddTool(mcp.NewTool("crash"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		os.Exit(3)
+		return nil, nil
+	})
+	if err := server.ServeStdio(mcpServer); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func Test_RestartPolicy(t *testing.T) {
+	cases := []struct {
+		policy   RestartPolicy
+		failed   bool
+		restarts int
+		expected bool
+	}{
+		{RestartPolicy{}, true, 0, true},
+		{RestartPolicy{}, false, 0, false},
+		{RestartPolicy{}, true, DEFAULT_MAX_RESTARTS, false},
+		{RestartPolicy{Mode: RESTART_MODES.Always}, false, 0, true},
+		{RestartPolicy{Mode: RESTART_MODES.Always, MaxRestarts: 2}, true, 2, false},
+		{RestartPolicy{Mode: RESTART_MODES.Always, MaxRestarts: -1}, true, 100, true},
+		{RestartPolicy{Mode: RESTART_MODES.Never}, true, 0, false},
+	}
+	for _, c := range cases {
+		if c.policy.ShouldRestart(c.failed, c.restarts) != c.expected {
+			t.Errorf("Expected %t for %+v (failed: %t, restarts: %d)", c.expected, c.policy, c.failed, c.restarts)
+		}
+	}
+
+	if (RestartPolicy{Mode: "sometimes"}).Validate() == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func Test_ServerProcessExit(t *testing.T) {
+	config := MCPServerConfig{
+		Name:    "crashy",
+		Type:    MCP_SERVERS_TYPE.Stdio,
+		Command: os.Args[0],
+		Env:     map[string]string{TEST_STDIO_SERVER_ENV: "1"},
+	}
+	mcpServer, err := ConnectServer(context.Background(), config, ConnectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelCtx()
+	_, err = mcpServer.Client.CallTool(ctx, mcp.CallToolRequest{Params: mcp.CallToolParams{Name: "crash"}})
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("Expected the call to fail with a lost connection, got %v", err)
+	}
+
+	select {
+	case <-mcpServer.Lost():
+	case <-ctx.Done():
+		t.Fatal("The exit of the process was never noticed")
+	}
+	if !errors.Is(mcpServer.LostReason(), ErrServerExited) {
+		t.Errorf("Expected the process to have exited, got %v", mcpServer.LostReason())
+	}
+	if err := mcpServer.Close(); err == nil {
+		t.Error("Expected the exit error of the process")
+	}
+}
+
+// Fails every request as if the connection was lost, calling `onRequest` first.
+type lostTransport struct {
+	silentTransport
+	onRequest func()
+}
+
+func (trans *lostTransport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	trans.onRequest()
+	return trans.silentTransport.SendRequest(ctx, request)
+}
+
+func Test_SessionRetriesIdempotentTools(t *testing.T) {
+	config := MCPServerConfig{Name: "crashy"}
+	calls := atomic.Int32{}
+	mcpServer := server.NewMCPServer("crashy", "1.0.0")
+	for _, tool := range []mcp.Tool{
+		mcp.NewTool("search", mcp.WithReadOnlyHintAnnotation(true)),
+		mcp.NewTool("delete"),
+	} {
+		mcpServer.AddTool(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			calls.Add(1)
+			return mcp.NewToolResultText(request.Params.Name), nil
+		})
+	}
+	reconnected, err := client.NewInProcessClient(mcpServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reconnected.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	newTools, err := ListServerTools(context.Background(), reconnected, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server reconnects as soon as a call fails.
+	tools := NewToolRegistry()
+	lost := NewMCPServer(config, MCP_SERVERS_TYPE.Stdio, nil)
+	lost.MarkLost(ErrServerExited)
+	lostClient := client.NewClient(cancellingTransport{
+		Interface: &lostTransport{onRequest: func() {
+			tools.SetServerTools(config.Name, newTools)
+		}},
+		connection:     lost.connection,
+		loseConnection: lost.loseConnection,
+	}, client.WithSession())
+	readOnly := true
+	tools.SetServerTools(config.Name, []MCPTool{
+		{Client: lostClient, ServerName: config.Name, Name: "search", ExposedName: "crashy__search", Annotations: mcp.ToolAnnotation{ReadOnlyHint: &readOnly}},
+		{Client: lostClient, ServerName: config.Name, Name: "delete", ExposedName: "crashy__delete"},
+	})
+
+	provider := &fakeProvider{responses: []llm.Response{
+		{
+			Message: llm.NewAssistantMessage(
+				llm.NewToolUseBlock("toolu_01", "crashy__search", nil),
+				llm.NewToolUseBlock("toolu_02", "crashy__delete", nil),
+			),
+			StopReason: llm.StopReasonToolUse,
+		},
+		{
+			Message:    llm.NewAssistantMessage(llm.NewTextBlock("Done.")),
+			StopReason: llm.StopReasonEndTurn,
+		},
+	}}
+	session := NewSession(provider, tools, llm.Request{})
+	events, err := session.Send(context.Background(), "Search and delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+
+	results := session.Messages()[2].Content
+	if len(results) != 2 || results[0].IsError || !results[1].IsError {
+		t.Errorf("Expected only the read-only tool to be retried, got %#v", results)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected a single call to the reconnected server, got %d", calls.Load())
+	}
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+type samplingTemperatureKey struct{}
+
+// Records on the context of a `sampling/createMessage` request whether the server gave a temperature,
+// since the client decodes a missing one as 0.
+func withSamplingTemperature(ctx context.Context, request transport.JSONRPCRequest) context.Context {
+	if request.Method != string(mcp.MethodSamplingCreateMessage) {
+		return ctx
+	}
+
+	params, err := json.Marshal(request.Params)
+	if err != nil {
+		return ctx
+	}
+	var temperature struct {
+		Temperature *float64 `json:"temperature"`
+	}
+	if err := json.Unmarshal(params, &temperature); err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, samplingTemperatureKey{}, temperature.Temperature != nil)
+}
+
+// The temperature asked by a `sampling/createMessage` request, false if the server didn't give one.
+// Servers connected without `ConnectServer` can't ask for a temperature of 0.
+func SamplingTemperature(ctx context.Context, params mcp.CreateMessageParams) (float64, bool) {
+	if found, ok := ctx.Value(samplingTemperatureKey{}).(bool); ok {
+		return params.Temperature, found
+	}
+	return params.Temperature, params.Temperature != 0
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func Test_SamplingTemperature(t *testing.T) {
+	cases := []struct {
+		params      any
+		temperature float64
+		found       bool
+	}{
+		{json.RawMessage(`{"maxTokens":100,"temperature":0}`), 0, true},
+		{map[string]any{"maxTokens": 100, "temperature": 0.7}, 0.7, true},
+		{json.RawMessage(`{"maxTokens":100}`), 0, false},
+	}
+	for _, c := range cases {
+		request := transport.JSONRPCRequest{Method: string(mcp.MethodSamplingCreateMessage), Params: c.params}
+		var params mcp.CreateMessageParams
+		raw, _ := json.Marshal(c.params)
+		if err := json.Unmarshal(raw, &params); err != nil {
+			t.Fatal(err)
+		}
+
+		temperature, found := SamplingTemperature(withSamplingTemperature(context.Background(), request), params)
+		if temperature != c.temperature || found != c.found {
+			t.Errorf("Expected (%g, %t) for %s, got (%g, %t)", c.temperature, c.found, raw, temperature, found)
+		}
+	}
+
+	// Without the raw request only a temperature over 0 is known to be given.
+	if _, found := SamplingTemperature(context.Background(), mcp.CreateMessageParams{}); found {
+		t.Error("Expected a missing temperature")
+	}
+}
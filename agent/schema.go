@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Keys that point to schema definitions referenced with `$ref`.
+var SCHEMA_DEFINITION_KEYS = []string{"$defs", "definitions"}
+
+// Keys Claude doesn't allow at the root of a tool input schema.
+var SCHEMA_ROOT_COMBINATORS = []string{"allOf", "anyOf", "oneOf"}
+
+var rawRequestId atomic.Int64
+
+// Lists a page of tools from an MCP server, keeping each input schema untouched in `RawInputSchema`.
+//
+// mcp-go only decodes `type`, `properties`, `required` and `$defs` of an input schema,
+// so the request is sent directly through the transport to get the whole schema.
+func ListToolsWithSchemas(ctx context.Context, mcpClient *client.Client, cursor mcp.Cursor) (*mcp.ListToolsResult, error) {
+	params := mcp.PaginatedParams{Cursor: cursor}
+	response, err := mcpClient.GetTransport().SendRequest(ctx, transport.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		// String IDs never collide with the numeric ones used by mcp-go.
+		ID:     mcp.NewRequestId(fmt.Sprintf("cliude-%d", rawRequestId.Add(1))),
+		Method: string(mcp.MethodToolsList),
+		Params: params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, errors.New(response.Error.Message)
+	}
+
+	var result mcp.ListToolsResult
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tools: %w", err)
+	}
+
+	var rawResult struct {
+		Tools []struct {
+			InputSchema json.RawMessage `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(response.Result, &rawResult); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tool schemas: %w", err)
+	}
+	for i := range result.Tools {
+		result.Tools[i].RawInputSchema = rawResult.Tools[i].InputSchema
+	}
+
+	return &result, nil
+}
+
+// Converts the input schema of an MCP tool into one every provider accepts.
+// `RawInputSchema` takes precedence over `InputSchema` when it's set.
+func ToolInputSchema(tool mcp.Tool) (map[string]any, error) {
+	raw := tool.RawInputSchema
+	if len(raw) == 0 {
+		var err error
+		raw, err = json.Marshal(tool.InputSchema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ConvertInputSchema(raw)
+}
+
+// Converts a JSON Schema into a tool input schema with a plain object at its root.
+//
+// Local `$ref`s are inlined (recursive ones are kept along with their definitions),
+// combinators at the root are merged into a single object schema
+// and every other keyword is preserved.
+func ConvertInputSchema(raw json.RawMessage) (map[string]any, error) {
+	schema := map[string]any{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, fmt.Errorf("invalid input schema: %w", err)
+	}
+
+	defs := map[string]any{}
+	for _, key := range SCHEMA_DEFINITION_KEYS {
+		if keyDefs, ok := schema[key].(map[string]any); ok {
+			for name, def := range keyDefs {
+				defs["#/"+key+"/"+name] = def
+			}
+		}
+	}
+
+	inlined, unresolved := inlineRefs(schema, defs, map[string]bool{})
+	schema = inlined.(map[string]any)
+	if !unresolved {
+		for _, key := range SCHEMA_DEFINITION_KEYS {
+			delete(schema, key)
+		}
+	}
+
+	schema = mergeRootCombinators(schema)
+	delete(schema, "$schema")
+	delete(schema, "$id")
+	schema["type"] = "object"
+	if _, ok := schema["properties"]; !ok {
+		schema["properties"] = map[string]any{}
+	}
+
+	return schema, nil
+}
+
+// Replaces every local `$ref` of a schema with a copy of the definition it points to.
+// Returns true if a recursive reference had to be left as is.
+func inlineRefs(node any, defs map[string]any, visiting map[string]bool) (any, bool) {
+	switch node := node.(type) {
+	case map[string]any:
+		if ref, ok := node["$ref"].(string); ok {
+			def, found := defs[ref]
+			if !found || visiting[ref] {
+				return node, found
+			}
+
+			visiting[ref] = true
+			resolved, unresolved := inlineRefs(def, defs, visiting)
+			delete(visiting, ref)
+
+			resolvedMap, ok := resolved.(map[string]any)
+			if !ok {
+				return resolved, unresolved
+			}
+			// Keywords next to a `$ref` (like `description`) override the definition.
+			merged := maps.Clone(resolvedMap)
+			for key, value := range node {
+				if key == "$ref" {
+					continue
+				}
+				value, siblingUnresolved := inlineRefs(value, defs, visiting)
+				unresolved = unresolved || siblingUnresolved
+				merged[key] = value
+			}
+			return merged, unresolved
+		}
+
+		result := make(map[string]any, len(node))
+		unresolved := false
+		for key, value := range node {
+			if slices.Contains(SCHEMA_DEFINITION_KEYS, key) {
+				// Definitions are kept untouched in case they're still referenced.
+				result[key] = value
+				continue
+			}
+			value, valueUnresolved := inlineRefs(value, defs, visiting)
+			unresolved = unresolved || valueUnresolved
+			result[key] = value
+		}
+		return result, unresolved
+
+	case []any:
+		result := make([]any, len(node))
+		unresolved := false
+		for i, value := range node {
+			value, valueUnresolved := inlineRefs(value, defs, visiting)
+			unresolved = unresolved || valueUnresolved
+			result[i] = value
+		}
+		return result, unresolved
+
+	default:
+		return node, false
+	}
+}
+
+// Claude requires the root of a tool schema to be a plain object,
+// so root level `allOf`, `anyOf` and `oneOf` are merged into it.
+//
+// With `allOf` every branch applies so all properties and requirements are kept.
+// With `anyOf` and `oneOf` the properties of every branch are kept,
+// but only the ones required by all branches stay required.
+func mergeRootCombinators(schema map[string]any) map[string]any {
+	if !slices.ContainsFunc(SCHEMA_ROOT_COMBINATORS, func(key string) bool { return schema[key] != nil }) {
+		return schema
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	if properties == nil {
+		properties = map[string]any{}
+	}
+	required := stringSet(schema["required"])
+
+	for _, key := range SCHEMA_ROOT_COMBINATORS {
+		branches, ok := schema[key].([]any)
+		if !ok {
+			continue
+		}
+		delete(schema, key)
+
+		var commonRequired map[string]bool
+		alternatives := make([]string, 0, len(branches))
+		for _, branch := range branches {
+			branch, ok := branch.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			if branchProperties, ok := branch["properties"].(map[string]any); ok {
+				names := slices.Sorted(maps.Keys(branchProperties))
+				alternatives = append(alternatives, strings.Join(names, ", "))
+				for name, property := range branchProperties {
+					if _, exists := properties[name]; !exists {
+						properties[name] = property
+					}
+				}
+			}
+
+			branchRequired := stringSet(branch["required"])
+			if key == "allOf" {
+				maps.Copy(required, branchRequired)
+			} else if commonRequired == nil {
+				commonRequired = branchRequired
+			} else {
+				maps.DeleteFunc(commonRequired, func(name string, _ bool) bool { return !branchRequired[name] })
+			}
+		}
+		maps.Copy(required, commonRequired)
+
+		if key != "allOf" && len(alternatives) > 1 {
+			note := fmt.Sprintf("Provide exactly one of these sets of arguments: (%s).", strings.Join(alternatives, ") or ("))
+			if description, ok := schema["description"].(string); ok && description != "" {
+				note = description + "\n" + note
+			}
+			schema["description"] = note
+		}
+	}
+
+	if len(properties) > 0 {
+		schema["properties"] = properties
+	}
+	if len(required) > 0 {
+		requiredList := make([]any, 0, len(required))
+		for _, name := range slices.Sorted(maps.Keys(required)) {
+			requiredList = append(requiredList, name)
+		}
+		schema["required"] = requiredList
+	}
+	return schema
+}
+
+func stringSet(value any) map[string]bool {
+	set := map[string]bool{}
+	values, _ := value.([]any)
+	for _, value := range values {
+		if value, ok := value.(string); ok {
+			set[value] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func marshalSchema(t *testing.T, schema map[string]any) map[string]any {
+	t.Helper()
+	bytes, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("Failed to marshal schema: %s", err)
+	}
+
+	result := map[string]any{}
+	if err := json.Unmarshal(bytes, &result); err != nil {
+		t.Fatalf("Failed to unmarshal schema: %s", err)
+	}
+	return result
+}
+
+func requiredNames(schema map[string]any) []string {
+	names := []string{}
+	required, _ := schema["required"].([]any)
+	for _, name := range required {
+		names = append(names, name.(string))
+	}
+	return names
+}
+
+// Schema of `browser_click` from the Playwright MCP server.
+func Test_ConvertInputSchema_KeepsRootKeywords(t *testing.T) {
+	schema, err := ConvertInputSchema(json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"element": {"type": "string", "description": "Human-readable element description"},
+			"ref": {"type": "string", "description": "Exact target element reference from the page snapshot"},
+			"button": {"type": "string", "enum": ["left", "right", "middle"]}
+		},
+		"required": ["element", "ref"],
+		"additionalProperties": false,
+		"$schema": "http://json-schema.org/draft-07/schema#"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := marshalSchema(t, schema)
+	if result["type"] != "object" {
+		t.Errorf("Schema should be an object, got %#v", result["type"])
+	}
+	if result["additionalProperties"] != false {
+		t.Errorf("`additionalProperties` should be kept, got %#v", result["additionalProperties"])
+	}
+	if _, found := result["$schema"]; found {
+		t.Error("`$schema` should be removed!")
+	}
+	if !slices.Equal(requiredNames(result), []string{"element", "ref"}) {
+		t.Errorf("Required should keep its order, got %#v", result["required"])
+	}
+}
+
+// Schema generated by pydantic for a FastMCP tool.
+func Test_ConvertInputSchema_InlinesRefs(t *testing.T) {
+	schema, err := ConvertInputSchema(json.RawMessage(`{
+		"$defs": {
+			"Sentiment": {"type": "string", "enum": ["positive", "negative"]},
+			"Review": {
+				"type": "object",
+				"properties": {
+					"text": {"type": "string"},
+					"sentiment": {"$ref": "#/$defs/Sentiment"}
+				},
+				"required": ["text"]
+			}
+		},
+		"type": "object",
+		"properties": {
+			"review": {"$ref": "#/$defs/Review", "description": "The review to analyze"},
+			"language": {"anyOf": [{"type": "string"}, {"type": "null"}], "default": null}
+		},
+		"required": ["review"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := marshalSchema(t, schema)
+	if _, found := result["$defs"]; found {
+		t.Error("`$defs` should be removed once every reference is inlined!")
+	}
+
+	review := result["properties"].(map[string]any)["review"].(map[string]any)
+	if review["description"] != "The review to analyze" {
+		t.Errorf("Keywords next to `$ref` should be kept, got %#v", review)
+	}
+	sentiment := review["properties"].(map[string]any)["sentiment"].(map[string]any)
+	if sentiment["type"] != "string" {
+		t.Errorf("Nested references should be inlined, got %#v", sentiment)
+	}
+}
+
+func Test_ConvertInputSchema_KeepsRecursiveRefs(t *testing.T) {
+	schema, err := ConvertInputSchema(json.RawMessage(`{
+		"definitions": {
+			"Node": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"children": {"type": "array", "items": {"$ref": "#/definitions/Node"}}
+				}
+			}
+		},
+		"type": "object",
+		"properties": {"tree": {"$ref": "#/definitions/Node"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := marshalSchema(t, schema)
+	if _, found := result["definitions"]; !found {
+		t.Error("Definitions should be kept while they're still referenced!")
+	}
+
+	tree := result["properties"].(map[string]any)["tree"].(map[string]any)
+	items := tree["properties"].(map[string]any)["children"].(map[string]any)["items"].(map[string]any)
+	if items["$ref"] != "#/definitions/Node" {
+		t.Errorf("Recursive references should be kept, got %#v", items)
+	}
+}
+
+func Test_ConvertInputSchema_MergesRootCombinators(t *testing.T) {
+	schema, err := ConvertInputSchema(json.RawMessage(`{
+		"type": "object",
+		"anyOf": [
+			{"properties": {"owner": {"type": "string"}, "repo": {"type": "string"}}, "required": ["owner", "repo"]},
+			{"properties": {"url": {"type": "string"}}, "required": ["url"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := marshalSchema(t, schema)
+	if _, found := result["anyOf"]; found {
+		t.Error("`anyOf` isn't allowed at the root!")
+	}
+	properties := result["properties"].(map[string]any)
+	if len(properties) != 3 {
+		t.Errorf("Properties of every branch should be kept, got %#v", properties)
+	}
+	if len(requiredNames(result)) != 0 {
+		t.Errorf("No property is required by every branch, got %#v", result["required"])
+	}
+	if result["description"] == nil {
+		t.Error("The alternatives should be described!")
+	}
+}
+
+func Test_ToolInputSchema_WithoutRawSchema(t *testing.T) {
+	tool := mcp.NewTool("search", mcp.WithString("query", mcp.Required()))
+	schema, err := ToolInputSchema(tool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(requiredNames(schema), []string{"query"}) {
+		t.Errorf("Expected `query` to be required, got %#v", schema["required"])
+	}
+	if _, found := schema["properties"].(map[string]any)["query"]; !found {
+		t.Errorf("Expected a `query` property, got %#v", schema["properties"])
+	}
+}
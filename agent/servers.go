@@ -0,0 +1,316 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+type MCPServerType string
+
+var MCP_SERVERS_TYPE = struct {
+	// Streamable HTTP.
+	Http MCPServerType
+	// Legacy HTTP with SSE of the 2024-11-05 spec.
+	Sse   MCPServerType
+	Stdio MCPServerType
+	// Tries streamable HTTP and falls back to SSE.
+	Auto MCPServerType
+}{
+	Http:  "http",
+	Sse:   "sse",
+	Stdio: "stdio",
+	Auto:  "auto",
+}
+
+type MCPServerConfig struct {
+	Name    string
+	Type    MCPServerType
+	URL     string
+	Command string
+	Args    []string
+	// Env variables of stdio servers, `${VAR}` is replaced by the variable of the host or `.env`.
+	Env map[string]string
+	// Dotenv file with more env variables of stdio servers.
+	EnvFile string
+	// Env variables of the host inherited by stdio servers, besides `DEFAULT_INHERITED_ENV`.
+	InheritEnv []string
+	// Working directory of stdio servers, the host's one if empty.
+	Cwd string
+	// Prefix used to namespace the tools of this server, defaults to `Name`.
+	// The LLM sees each tool as `<Prefix>__<tool name>`.
+	Prefix string
+	// Headers sent on every request to HTTP servers.
+	Headers map[string]string
+	// Env variable with a token sent as `Authorization: Bearer <token>` to HTTP servers.
+	BearerTokenEnv string
+	OAuth          OAuthConfig
+	// What happens when the server crashes or its connection is lost.
+	Restart RestartPolicy
+}
+
+// Returned when the server process or connection couldn't even be started.
+var ErrServerStart = errors.New("failed to start server")
+
+// Returned by requests to a server once its connection is lost.
+var ErrConnectionLost = errors.New("lost the connection to the server")
+
+// Why the connection to a stdio server is lost when its process exits.
+var ErrServerExited = errors.New("the server process exited")
+
+// Why the connection is lost once the host closes it.
+var ErrServerClosed = errors.New("the connection was closed")
+
+// Checks the fields the server's `Type` needs.
+func ValidateServerConfig(config MCPServerConfig) error {
+	switch config.Type {
+	case MCP_SERVERS_TYPE.Http, MCP_SERVERS_TYPE.Sse, MCP_SERVERS_TYPE.Auto:
+		if config.URL == "" {
+			return fmt.Errorf("the server `%s` has no `URL`", config.Name)
+		}
+	case MCP_SERVERS_TYPE.Stdio:
+		if config.Command == "" {
+			return fmt.Errorf("the server `%s` has no `Command`", config.Name)
+		}
+	default:
+		return fmt.Errorf(
+			"the server `%s` has the unknown type `%s`, it must be one of `%s`, `%s`, `%s` or `%s`",
+			config.Name,
+			config.Type,
+			MCP_SERVERS_TYPE.Http,
+			MCP_SERVERS_TYPE.Sse,
+			MCP_SERVERS_TYPE.Stdio,
+			MCP_SERVERS_TYPE.Auto,
+		)
+	}
+	if err := config.Restart.Validate(); err != nil {
+		return fmt.Errorf("the server `%s` has an invalid restart policy: %w", config.Name, err)
+	}
+	return nil
+}
+
+// An MCP server the host is connected to.
+type MCPServer struct {
+	Config MCPServerConfig
+	// Transport used, only differs from the config's type with `auto`.
+	Type         MCPServerType
+	Client       *client.Client
+	Capabilities mcp.ServerCapabilities
+	// Cancelled once the connection is lost.
+	connection     context.Context
+	loseConnection context.CancelCauseFunc
+}
+
+// Builds a server whose connection is alive until it's closed or marked as lost.
+func NewMCPServer(config MCPServerConfig, serverType MCPServerType, mcpClient *client.Client) *MCPServer {
+	connection, loseConnection := context.WithCancelCause(context.Background())
+	return &MCPServer{
+		Config:         config,
+		Type:           serverType,
+		Client:         mcpClient,
+		connection:     connection,
+		loseConnection: loseConnection,
+	}
+}
+
+// Closed once the connection is lost, like when the process of a stdio server exits.
+func (server *MCPServer) Lost() <-chan struct{} {
+	return server.connection.Done()
+}
+
+// Why the connection was lost, nil while it's alive.
+func (server *MCPServer) LostReason() error {
+	return context.Cause(server.connection)
+}
+
+// Marks the connection as lost, failing the requests waiting for a response.
+func (server *MCPServer) MarkLost(reason error) {
+	server.loseConnection(reason)
+}
+
+// Closes the connection (stopping the process of stdio servers) and marks it as lost.
+// Returns why the process failed, if it did.
+func (server *MCPServer) Close() error {
+	server.loseConnection(ErrServerClosed)
+	return server.Client.Close()
+}
+
+// How the host connects to an MCP server.
+type ConnectOptions struct {
+	// Receives every notification of the server, if not nil.
+	OnNotification func(*client.Client, mcp.JSONRPCNotification)
+	// Shows the user where to authorize the host with OAuth, `PrintAuthorizationURL` if nil.
+	ShowAuthorizationURL func(serverName string, authURL string)
+	// Passed to the client, like a sampling handler.
+	ClientOptions []client.ClientOption
+	// Logs the connection and what stdio servers write to stderr, nothing is logged if nil.
+	Logger *log.Logger
+}
+
+// Starts an MCP server (or connects to it) and initializes the session.
+func ConnectServer(ctx context.Context, config MCPServerConfig, options ConnectOptions) (*MCPServer, error) {
+	if err := ValidateServerConfig(config); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrServerStart, err)
+	}
+	if config.Type != MCP_SERVERS_TYPE.Auto {
+		return connectServer(ctx, config, config.Type, options)
+	}
+
+	server, err := connectServer(ctx, config, MCP_SERVERS_TYPE.Http, options)
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrAuthorization) {
+		return server, err
+	}
+	orDiscard(options.Logger).Printf("Failed to connect to `%s` with streamable HTTP, falling back to SSE: %s", config.Name, err)
+	return connectServer(ctx, config, MCP_SERVERS_TYPE.Sse, options)
+}
+
+func connectServer(ctx context.Context, config MCPServerConfig, serverType MCPServerType, options ConnectOptions) (*MCPServer, error) {
+	logger := orDiscard(options.Logger)
+	var trans transport.Interface
+	var stdio *transport.Stdio
+	var tokenStore *FileTokenStore
+	if serverType == MCP_SERVERS_TYPE.Stdio {
+		logger.Println("Connecting to (stdio) client:", config.Name, config.Command)
+		var err error
+		stdio, err = stdioTransport(config)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrServerStart, err)
+		}
+		trans = stdio
+	} else {
+		logger.Printf("Connecting to (%s) client: %s %s", serverType, config.Name, config.URL)
+		headers, oauthConfig, store, err := httpAuthentication(config)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrServerStart, err)
+		}
+		tokenStore = store
+
+		if serverType == MCP_SERVERS_TYPE.Sse {
+			sseOptions := []transport.ClientOption{transport.WithHeaders(headers)}
+			if oauthConfig != nil {
+				sseOptions = append(sseOptions, transport.WithOAuth(*oauthConfig))
+			}
+			trans, err = transport.NewSSE(config.URL, sseOptions...)
+		} else {
+			httpOptions := []transport.StreamableHTTPCOption{transport.WithHTTPHeaders(headers)}
+			if oauthConfig != nil {
+				httpOptions = append(httpOptions, transport.WithHTTPOAuth(*oauthConfig))
+			}
+			trans, err = transport.NewStreamableHTTP(config.URL, httpOptions...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid URL `%s`: %w", ErrServerStart, config.URL, err)
+		}
+	}
+
+	// The server asks for authorization on the first request it receives,
+	// which is the start with SSE and the initialization otherwise.
+	withAuthorization := func(step func() error) error {
+		err := step()
+		if err == nil || tokenStore == nil || !client.IsOAuthAuthorizationRequiredError(err) {
+			return err
+		}
+		logger.Printf("`%s` requires authorization", config.Name)
+		if err := authorize(ctx, config, client.GetOAuthHandler(err), tokenStore, options); err != nil {
+			return fmt.Errorf("%w: %w", ErrAuthorization, err)
+		}
+		return step()
+	}
+
+	server := NewMCPServer(config, serverType, nil)
+	mcpClient := client.NewClient(cancellingTransport{
+		Interface:      trans,
+		connection:     server.connection,
+		loseConnection: server.loseConnection,
+		logger:         options.Logger,
+	}, options.ClientOptions...)
+	server.Client = mcpClient
+	err := withAuthorization(func() error {
+		return mcpClient.Start(ctx)
+	})
+	if err != nil {
+		server.MarkLost(err)
+		return nil, fmt.Errorf("%w: %w", ErrServerStart, err)
+	}
+
+	if stdio != nil {
+		go watchStderr(config.Name, stdio.Stderr(), server.loseConnection, logger)
+	}
+	mcpClient.OnConnectionLost(func(err error) {
+		logger.Printf("Lost the connection to `%s`: %s", config.Name, err)
+		server.MarkLost(err)
+	})
+
+	if options.OnNotification != nil {
+		mcpClient.OnNotification(func(notification mcp.JSONRPCNotification) {
+			options.OnNotification(mcpClient, notification)
+		})
+	}
+
+	logger.Printf("Initializing client!")
+	var initResult *mcp.InitializeResult
+	err = withAuthorization(func() error {
+		var err error
+		initResult, err = mcpClient.Initialize(ctx, mcp.InitializeRequest{
+			Params: mcp.InitializeParams{
+				ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
+				ClientInfo: mcp.Implementation{
+					Name:    "CLIude",
+					Version: "1.0.0",
+				},
+				Capabilities: mcp.ClientCapabilities{},
+			},
+		})
+		return err
+	})
+	if err != nil {
+		server.Close()
+		return nil, fmt.Errorf("failed to initialize client: %w", err)
+	}
+	logger.Printf("Server capabilities:\n%#v", initResult)
+
+	server.Capabilities = initResult.Capabilities
+	return server, nil
+}
+
+// Logs what a stdio server writes to stderr, which reaches its end once the process exits.
+func watchStderr(serverName string, stderr io.Reader, loseConnection context.CancelCauseFunc, logger *log.Logger) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		logger.Printf("[%s] %s", serverName, scanner.Text())
+	}
+	loseConnection(ErrServerExited)
+}
+
+// Headers and OAuth config (nil if disabled) that authenticate with an HTTP server,
+// along with the store of the OAuth tokens.
+func httpAuthentication(config MCPServerConfig) (map[string]string, *transport.OAuthConfig, *FileTokenStore, error) {
+	headers := make(map[string]string, len(config.Headers)+1)
+	for name, value := range config.Headers {
+		headers[name] = value
+	}
+	if config.BearerTokenEnv != "" {
+		token, found := os.LookupEnv(config.BearerTokenEnv)
+		if !found {
+			return nil, nil, nil, fmt.Errorf("no `%s` env variable found for the bearer token", config.BearerTokenEnv)
+		}
+		headers["Authorization"] = "Bearer " + token
+	}
+
+	if !config.OAuth.Enabled {
+		return headers, nil, nil, nil
+	}
+	oauthConfig, store, err := transportOAuthConfig(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return headers, &oauthConfig, store, nil
+}
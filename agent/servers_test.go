@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func Test_ValidateServerConfig(t *testing.T) {
+	valid := []MCPServerConfig{
+		{Name: "a", Type: MCP_SERVERS_TYPE.Stdio, Command: "python3"},
+		{Name: "b", Type: MCP_SERVERS_TYPE.Http, URL: "http://localhost:8080/mcp"},
+		{Name: "c", Type: MCP_SERVERS_TYPE.Sse, URL: "http://localhost:8080/sse"},
+		{Name: "d", Type: MCP_SERVERS_TYPE.Auto, URL: "http://localhost:8080"},
+	}
+	for _, config := range valid {
+		if err := ValidateServerConfig(config); err != nil {
+			t.Errorf("Unexpected error for `%s`: %s", config.Name, err)
+		}
+	}
+
+	invalid := []MCPServerConfig{
+		{Name: "a", Command: "python3"},
+		{Name: "b", Type: "websocket", URL: "ws://localhost:8080"},
+		{Name: "c", Type: MCP_SERVERS_TYPE.Stdio},
+		{Name: "d", Type: MCP_SERVERS_TYPE.Sse},
+	}
+	for _, config := range invalid {
+		if err := ValidateServerConfig(config); err == nil {
+			t.Errorf("Expected an error for `%s`", config.Name)
+		}
+	}
+
+	_, err := ConnectServer(context.Background(), MCPServerConfig{Name: "e", Type: "websocket"}, ConnectOptions{})
+	if !errors.Is(err, ErrServerStart) {
+		t.Errorf("Expected a start error for an unknown type, got %v", err)
+	}
+}
+
+func Test_ConnectServerSSE(t *testing.T) {
+	httpServer := httptest.NewServer(server.NewSSEServer(server.NewMCPServer("test", "1.0.0")))
+	defer httpServer.Close()
+
+	for _, serverType := range []MCPServerType{MCP_SERVERS_TYPE.Sse, MCP_SERVERS_TYPE.Auto} {
+		config := MCPServerConfig{Name: "test", Type: serverType, URL: httpServer.URL + "/sse"}
+		mcpServer, err := ConnectServer(context.Background(), config, ConnectOptions{})
+		if err != nil {
+			t.Fatalf("Failed to connect with `%s`: %s", serverType, err)
+		}
+		if mcpServer.Type != MCP_SERVERS_TYPE.Sse {
+			t.Errorf("Expected the SSE transport with `%s`, got `%s`", serverType, mcpServer.Type)
+		}
+		mcpServer.Client.Close()
+	}
+
+	httpServer = httptest.NewServer(server.NewStreamableHTTPServer(server.NewMCPServer("test", "1.0.0")))
+	defer httpServer.Close()
+
+	config := MCPServerConfig{Name: "test", Type: MCP_SERVERS_TYPE.Auto, URL: httpServer.URL + "/mcp"}
+	mcpServer, err := ConnectServer(context.Background(), config, ConnectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mcpServer.Type != MCP_SERVERS_TYPE.Http {
+		t.Errorf("Expected the streamable HTTP transport, got `%s`", mcpServer.Type)
+	}
+	mcpServer.Client.Close()
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/joho/godotenv"
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// Env variables every stdio server inherits from the host, since most programs need them to run.
+// Any other variable must be on the server's `InheritEnv`, so secrets like `API_KEY` aren't leaked.
+var DEFAULT_INHERITED_ENV = []string{"PATH", "HOME", "USER", "LANG", "TERM", "TMPDIR", "SYSTEMROOT"}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Replaces every `${VAR}` of `value`, failing if a variable isn't defined.
+func expandEnv(value string, lookup func(string) (string, bool)) (string, error) {
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReference.FindStringSubmatch(reference)[1]
+		variable, found := lookup(name)
+		if !found {
+			missing = append(missing, name)
+		}
+		return variable
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined env variables %v", missing)
+	}
+	return expanded, nil
+}
+
+// The environment of a stdio server: the inherited variables of the host,
+// then the ones of its `EnvFile` and then its `Env`, each one overriding the previous ones.
+// `${VAR}` on `Env` is replaced by the variable of the env file or the host (which includes `.env`).
+func serverEnvironment(config MCPServerConfig) ([]string, error) {
+	env := map[string]string{}
+	for _, name := range slices.Concat(DEFAULT_INHERITED_ENV, config.InheritEnv) {
+		if value, found := os.LookupEnv(name); found {
+			env[name] = value
+		}
+	}
+
+	fileEnv := map[string]string{}
+	if config.EnvFile != "" {
+		var err error
+		fileEnv, err = godotenv.Read(config.EnvFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env file `%s`: %w", config.EnvFile, err)
+		}
+		for name, value := range fileEnv {
+			env[name] = value
+		}
+	}
+
+	lookup := func(name string) (string, bool) {
+		if value, found := fileEnv[name]; found {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+	for name, value := range config.Env {
+		expanded, err := expandEnv(value, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("invalid env variable `%s`: %w", name, err)
+		}
+		env[name] = expanded
+	}
+
+	result := make([]string, 0, len(env))
+	for name, value := range env {
+		result = append(result, name+"="+value)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Builds the transport that runs a stdio server with only its own environment, on its `Cwd`.
+// `${VAR}` on the command, args and cwd are replaced by the variables of the host.
+func stdioTransport(config MCPServerConfig) (*transport.Stdio, error) {
+	env, err := serverEnvironment(config)
+	if err != nil {
+		return nil, err
+	}
+
+	command, err := expandEnv(config.Command, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command: %w", err)
+	}
+	args := make([]string, len(config.Args))
+	for i, arg := range config.Args {
+		args[i], err = expandEnv(arg, os.LookupEnv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arg `%s`: %w", arg, err)
+		}
+	}
+	cwd, err := expandEnv(config.Cwd, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cwd: %w", err)
+	}
+
+	// The default command of the transport always inherits the whole environment of the host.
+	return transport.NewStdioWithOptions(command, env, args, transport.WithCommandFunc(
+		func(ctx context.Context, command string, env []string, args []string) (*exec.Cmd, error) {
+			cmd := exec.CommandContext(ctx, command, args...)
+			cmd.Env = env
+			cmd.Dir = cwd
+			return cmd, nil
+		},
+	)), nil
+}
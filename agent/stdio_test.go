@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_ServerEnvironment(t *testing.T) {
+	t.Setenv("API_KEY", "anthropic")
+	t.Setenv("GITHUB_TOKEN", "github")
+	t.Setenv("EDITOR", "vim")
+
+	envFile := filepath.Join(t.TempDir(), "server.env")
+	if err := os.WriteFile(envFile, []byte("DB_URL=postgres://localhost\nEDITOR=nano\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := serverEnvironment(MCPServerConfig{
+		Name:       "test",
+		InheritEnv: []string{"EDITOR"},
+		EnvFile:    envFile,
+		Env: map[string]string{
+			"GITHUB_PERSONAL_ACCESS_TOKEN": "${GITHUB_TOKEN}",
+			"DATABASE":                     "${DB_URL}/test",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"DATABASE=postgres://localhost/test",
+		"DB_URL=postgres://localhost",
+		"EDITOR=nano",
+		"GITHUB_PERSONAL_ACCESS_TOKEN=github",
+	}
+	for _, variable := range expected {
+		if !slices.Contains(env, variable) {
+			t.Errorf("Expected `%s` on the environment: %v", variable, env)
+		}
+	}
+	for _, variable := range env {
+		if variable == "API_KEY=anthropic" || variable == "GITHUB_TOKEN=github" {
+			t.Errorf("Leaked `%s` to the server", variable)
+		}
+	}
+	if path, found := os.LookupEnv("PATH"); found && !slices.Contains(env, "PATH="+path) {
+		t.Errorf("Expected the server to inherit `PATH`: %v", env)
+	}
+
+	_, err = serverEnvironment(MCPServerConfig{Name: "test", Env: map[string]string{"TOKEN": "${UNDEFINED_TEST_VARIABLE}"}})
+	if err == nil {
+		t.Error("Expected an error for an undefined variable")
+	}
+}
+
+func Test_ExpandEnv(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		value, found := map[string]string{"HOME": "/home/user", "EMPTY": ""}[name]
+		return value, found
+	}
+
+	expanded, err := expandEnv("${HOME}/servers/$HOME${EMPTY}", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expanded != "/home/user/servers/$HOME" {
+		t.Errorf("Unexpected expansion: `%s`", expanded)
+	}
+
+	if _, err := expandEnv("${HOME} ${MISSING}", lookup); err == nil {
+		t.Error("Expected an error for an undefined variable")
+	}
+}
@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type ServerState string
+
+var SERVER_STATES = struct {
+	Connecting   ServerState
+	Ready        ServerState
+	Failed       ServerState
+	Disconnected ServerState
+}{
+	Connecting:   "connecting",
+	Ready:        "ready",
+	Failed:       "failed",
+	Disconnected: "disconnected",
+}
+
+// Delay before the first retry of a failed connection, doubled on each retry.
+const SERVER_RETRY_INITIAL_DELAY = 1 * time.Second
+
+const SERVER_RETRY_MAX_DELAY = 30 * time.Second
+
+// Max time listing what a server that just connected offers.
+const SERVER_LIST_TIMEOUT = 1 * time.Minute
+
+// How often HTTP servers are pinged to notice a lost session.
+// Stdio servers aren't pinged, their process exiting is noticed right away.
+const SERVER_HEALTH_CHECK_INTERVAL = 30 * time.Second
+
+const SERVER_PING_TIMEOUT = 10 * time.Second
+
+// A server connected for this long is considered healthy again,
+// so crashes hours apart don't exhaust its `MaxRestarts`.
+const SERVER_HEALTHY_UPTIME = 10 * time.Minute
+
+// The state of a supervised MCP server.
+type ServerStatus struct {
+	Config MCPServerConfig
+	State  ServerState
+	// Transport used once it's ready, only differs from the config's type with `auto`.
+	Type     MCPServerType
+	Attempts int
+	// Times the server was restarted after it crashed or disconnected,
+	// since it last stayed up for `SERVER_HEALTHY_UPTIME`.
+	Restarts int
+	// Why the last attempt failed or the server disconnected.
+	Err error
+	// Set while waiting to retry a failed connection or restart the server.
+	NextRetry time.Time
+	Tools     int
+}
+
+// Waiting for a retry or the user, so the state may still change on its own.
+func (status ServerStatus) Pending() bool {
+	return status.State == SERVER_STATES.Connecting || !status.NextRetry.IsZero()
+}
+
+// Delay before the retry that follows `attempts` failed attempts.
+func RetryDelay(attempts int) time.Duration {
+	delay := SERVER_RETRY_INITIAL_DELAY
+	for i := 1; i < attempts && delay < SERVER_RETRY_MAX_DELAY; i++ {
+		delay *= 2
+	}
+	return min(delay, SERVER_RETRY_MAX_DELAY)
+}
+
+// The restarts that count against the restart policy of a server that was connected for `uptime`.
+func restartsAfterUptime(restarts int, uptime time.Duration) int {
+	if uptime >= SERVER_HEALTHY_UPTIME {
+		return 0
+	}
+	return restarts
+}
+
+// Connects to a server, like `ConnectServer` with the options of the host.
+type ServerConnector func(ctx context.Context, config MCPServerConfig) (*MCPServer, error)
+
+// Keeps an MCP server connected: every time the connection is lost it's closed and,
+// if its restart policy allows it, connected again. Its tools are kept on `Tools` while it's ready.
+type Supervisor struct {
+	Config  MCPServerConfig
+	Connect ServerConnector
+	Tools   *ToolRegistry
+	// Attempts to connect each time the server is started, before giving up. 1 if 0.
+	Attempts int
+	// Called (if not nil) on every change of state, on the goroutine of `Run`.
+	// `server` is only set once it's ready, after its tools are on `Tools`.
+	OnStatus func(status ServerStatus, server *MCPServer)
+	// Nothing is logged if nil.
+	Logger *log.Logger
+}
+
+// Supervises the server until the context is done or it won't be restarted anymore.
+func (supervisor *Supervisor) Run(ctx context.Context) {
+	config := supervisor.Config
+	logger := orDiscard(supervisor.Logger)
+	status := ServerStatus{Config: config}
+	for {
+		server := supervisor.connectWithRetry(ctx, &status)
+		if server == nil {
+			return
+		}
+		supervisor.notify(status, server)
+
+		connectedAt := time.Now()
+		reason := supervisor.watch(ctx, server)
+		// The exit error of a stdio server tells if it crashed.
+		exitErr := server.Close()
+		supervisor.Tools.SetServerTools(config.Name, nil)
+		if ctx.Err() != nil {
+			return
+		}
+
+		failed := exitErr != nil || !errors.Is(reason, ErrServerExited)
+		logger.Printf("Lost the connection to `%s` (failed: %t): %s", config.Name, failed, reason)
+		status.State = SERVER_STATES.Disconnected
+		status.Err = reason
+		if exitErr != nil {
+			status.Err = fmt.Errorf("%w: %w", reason, exitErr)
+		}
+
+		status.Restarts = restartsAfterUptime(status.Restarts, time.Since(connectedAt))
+		restart := config.Restart.ShouldRestart(failed, status.Restarts)
+		// Restarts back off like connection retries, so a crash loop doesn't flood the log.
+		delay := RetryDelay(status.Restarts + 1)
+		status.NextRetry = time.Time{}
+		if restart {
+			status.NextRetry = time.Now().Add(delay)
+		}
+		supervisor.notify(status, nil)
+		if !restart {
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		status.Restarts++
+		status.Attempts = 0
+	}
+}
+
+func (supervisor *Supervisor) notify(status ServerStatus, server *MCPServer) {
+	if supervisor.OnStatus != nil {
+		supervisor.OnStatus(status, server)
+	}
+}
+
+// Tries to connect to the server up to `Attempts` times, waiting longer after each failure.
+// Returns nil if every attempt failed or the context is done.
+func (supervisor *Supervisor) connectWithRetry(ctx context.Context, status *ServerStatus) *MCPServer {
+	config := supervisor.Config
+	attempts := max(supervisor.Attempts, 1)
+	for status.Attempts < attempts {
+		status.State = SERVER_STATES.Connecting
+		status.NextRetry = time.Time{}
+		status.Attempts++
+		supervisor.notify(*status, nil)
+
+		server, tools, err := supervisor.connect(ctx)
+		if err == nil {
+			supervisor.Tools.SetServerTools(config.Name, tools)
+			status.State = SERVER_STATES.Ready
+			status.Type = server.Type
+			status.Err = nil
+			status.Tools = len(tools)
+			return server
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		orDiscard(supervisor.Logger).Printf("Failed to connect to `%s` (attempt %d/%d): %s", config.Name, status.Attempts, attempts, err)
+		status.State = SERVER_STATES.Failed
+		status.Err = err
+		if status.Attempts == attempts {
+			supervisor.notify(*status, nil)
+			return nil
+		}
+
+		delay := RetryDelay(status.Attempts)
+		status.NextRetry = time.Now().Add(delay)
+		supervisor.notify(*status, nil)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// Connects to the server and lists its tools, failing to list them fails the connection.
+func (supervisor *Supervisor) connect(ctx context.Context) (*MCPServer, []MCPTool, error) {
+	server, err := supervisor.Connect(ctx, supervisor.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if server.Capabilities.Tools == nil {
+		return server, nil, nil
+	}
+
+	listCtx, cancelList := context.WithTimeout(ctx, SERVER_LIST_TIMEOUT)
+	defer cancelList()
+	tools, err := ListServerTools(listCtx, server.Client, supervisor.Config, supervisor.Logger)
+	if err != nil {
+		server.Close()
+		return nil, nil, fmt.Errorf("failed to obtain tools: %w", err)
+	}
+	return server, tools, nil
+}
+
+// Waits until the connection to the server is lost or the context is done, returning why.
+// HTTP servers are pinged regularly, since a lost session is only noticed on a request.
+func (supervisor *Supervisor) watch(ctx context.Context, server *MCPServer) error {
+	var healthChecks <-chan time.Time
+	if server.Type != MCP_SERVERS_TYPE.Stdio {
+		ticker := time.NewTicker(SERVER_HEALTH_CHECK_INTERVAL)
+		defer ticker.Stop()
+		healthChecks = ticker.C
+	}
+
+	for {
+		select {
+		case <-server.Lost():
+			return server.LostReason()
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-healthChecks:
+			pingCtx, cancelPing := context.WithTimeout(ctx, SERVER_PING_TIMEOUT)
+			err := server.Client.Ping(pingCtx)
+			cancelPing()
+			if err != nil && ctx.Err() == nil {
+				orDiscard(supervisor.Logger).Printf("Failed to ping `%s`: %s", server.Config.Name, err)
+				server.MarkLost(fmt.Errorf("%w: %w", ErrConnectionLost, err))
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func Test_RetryDelay(t *testing.T) {
+	expected := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i, delay := range expected {
+		if RetryDelay(i+1) != delay {
+			t.Errorf("Expected a delay of %s after %d attempts, got %s", delay, i+1, RetryDelay(i+1))
+		}
+	}
+}
+
+func Test_RestartsAfterUptime(t *testing.T) {
+	if restartsAfterUptime(4, time.Minute) != 4 {
+		t.Error("Expected the restarts of a server that crashed soon after connecting to be kept")
+	}
+	if restartsAfterUptime(4, SERVER_HEALTHY_UPTIME) != 0 {
+		t.Error("Expected the restarts of a healthy server to be reset")
+	}
+}
+
+// Connects to an in-process server with a `search` tool.
+func connectSearchServer(ctx context.Context, config MCPServerConfig) (*MCPServer, error) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	mcpServer.AddTool(mcp.NewTool("search"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("found"), nil
+	})
+	mcpClient, err := client.NewInProcessClient(mcpServer)
+	if err != nil {
+		return nil, err
+	}
+	initResult, err := mcpClient.Initialize(ctx, mcp.InitializeRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	connected := NewMCPServer(config, config.Type, mcpClient)
+	connected.Capabilities = initResult.Capabilities
+	return connected, nil
+}
+
+func Test_SupervisorRetries(t *testing.T) {
+	attempts := 0
+	statuses := []ServerStatus{}
+	supervisor := Supervisor{
+		Config: MCPServerConfig{Name: "down", Type: MCP_SERVERS_TYPE.Stdio},
+		Connect: func(ctx context.Context, config MCPServerConfig) (*MCPServer, error) {
+			attempts++
+			return nil, errors.New("connection refused")
+		},
+		Tools:    NewToolRegistry(),
+		Attempts: 2,
+		OnStatus: func(status ServerStatus, server *MCPServer) {
+			statuses = append(statuses, status)
+		},
+	}
+	supervisor.Run(context.Background())
+
+	last := statuses[len(statuses)-1]
+	if attempts != 2 || last.State != SERVER_STATES.Failed || last.Attempts != 2 || last.Err == nil || last.Pending() {
+		t.Errorf("Expected the supervisor to give up after two attempts: %+v", last)
+	}
+}
+
+func Test_SupervisorRestarts(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	servers := make(chan *MCPServer, 2)
+	statuses := make(chan ServerStatus, 16)
+	tools := NewToolRegistry()
+	supervisor := Supervisor{
+		Config: MCPServerConfig{
+			Name:    "crashy",
+			Type:    MCP_SERVERS_TYPE.Stdio,
+			Restart: RestartPolicy{MaxRestarts: 1},
+		},
+		Connect: connectSearchServer,
+		Tools:   tools,
+		OnStatus: func(status ServerStatus, server *MCPServer) {
+			if server != nil {
+				if _, found := tools.Find("crashy__search"); !found || status.Tools != 1 {
+					t.Errorf("Expected the tools of the ready server to be registered: %+v", status)
+				}
+				servers <- server
+			}
+			statuses <- status
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		supervisor.Run(ctx)
+	}()
+
+	// The first crash is restarted, the second one isn't since it was already restarted once.
+	for restarts := range 2 {
+		(<-servers).MarkLost(errors.New("crashed"))
+		var status ServerStatus
+		for status.State != SERVER_STATES.Disconnected {
+			status = <-statuses
+		}
+		if status.Restarts != restarts || status.Pending() != (restarts == 0) {
+			t.Errorf("Unexpected state after crash %d: %+v", restarts+1, status)
+		}
+	}
+	<-done
+
+	if _, found := tools.Find("crashy__search"); found {
+		t.Error("Expected the tools of the disconnected server to be removed")
+	}
+}
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Image formats accepted inside a tool_result.
+var SUPPORTED_IMAGE_TYPES = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+}
+
+// Converts the MCP response of a tool into a single tool_result block for the LLM.
+//
+// A tool_result can only contain text and images, so any other
+// content the tool returned (like PDF documents) is returned as extra blocks
+// that must be sent on the same message, after every tool_result.
+func ToolResultBlocks(msg ToolResponse) (llm.Block, []llm.Block) {
+	content := make([]llm.Block, 0, len(msg.MCPResponse.Content))
+	extra := []llm.Block{}
+
+	for _, ct := range msg.MCPResponse.Content {
+		switch ct := ct.(type) {
+		case mcp.TextContent:
+			content = append(content, textResultContent(ct.Text))
+
+		case mcp.ImageContent:
+			content = append(content, imageResultContent(ct.MIMEType, ct.Data, "tool output"))
+
+		case mcp.AudioContent:
+			content = append(content, textResultContent(fmt.Sprintf(
+				"[The tool returned %s audio (%d bytes), which can't be listened to. Ask the user to play it if needed.]",
+				ct.MIMEType,
+				base64.StdEncoding.DecodedLen(len(ct.Data)),
+			)))
+
+		case mcp.ResourceLink:
+			content = append(content, textResultContent(DescribeResourceLink(ct)))
+
+		case mcp.EmbeddedResource:
+			switch resource := ct.Resource.(type) {
+			case mcp.TextResourceContents:
+				content = append(content, textResultContent(fmt.Sprintf("Resource `%s`:\n%s", resource.URI, resource.Text)))
+
+			case mcp.BlobResourceContents:
+				if slices.Contains(SUPPORTED_IMAGE_TYPES, resource.MIMEType) {
+					content = append(content, imageResultContent(resource.MIMEType, resource.Blob, resource.URI))
+				} else if resource.MIMEType == "application/pdf" {
+					content = append(content, textResultContent(fmt.Sprintf("Resource `%s` is attached as a document after the tool results.", resource.URI)))
+					extra = append(extra, llm.NewDocumentBlock(resource.MIMEType, resource.Blob, resource.URI))
+				} else if IsTextMIMEType(resource.MIMEType) {
+					text, err := base64.StdEncoding.DecodeString(resource.Blob)
+					if err != nil {
+						content = append(content, textResultContent(fmt.Sprintf("[Resource `%s` couldn't be decoded: %s]", resource.URI, err)))
+					} else {
+						content = append(content, textResultContent(fmt.Sprintf("Resource `%s`:\n%s", resource.URI, text)))
+					}
+				} else {
+					content = append(content, textResultContent(fmt.Sprintf(
+						"[Resource `%s` contains binary data of type `%s` that can't be displayed.]",
+						resource.URI,
+						resource.MIMEType,
+					)))
+				}
+
+			default:
+				content = append(content, textResultContent("[The tool returned an unknown kind of resource.]"))
+			}
+
+		default:
+			content = append(content, textResultContent("[The tool returned an unsupported kind of content.]"))
+		}
+	}
+
+	return llm.NewToolResultBlock(msg.ToolId, content, msg.IsError), extra
+}
+
+func textResultContent(text string) llm.Block {
+	return llm.NewTextBlock(text)
+}
+
+// Creates an image block from base64 data, falling back to text if the LLM can't read the format.
+func imageResultContent(mimeType string, data string, source string) llm.Block {
+	if !slices.Contains(SUPPORTED_IMAGE_TYPES, mimeType) {
+		return textResultContent(fmt.Sprintf("[Image from %s has the unsupported format `%s`.]", source, mimeType))
+	}
+
+	return llm.NewImageBlock(mimeType, data)
+}
+
+func DescribeResourceLink(link mcp.ResourceLink) string {
+	description := strings.Builder{}
+	description.WriteString("Resource link: ")
+	description.WriteString(link.Name)
+	description.WriteString(" (")
+	description.WriteString(link.URI)
+	description.WriteString(")")
+	if link.MIMEType != "" {
+		description.WriteString(" [")
+		description.WriteString(link.MIMEType)
+		description.WriteString("]")
+	}
+	if link.Description != "" {
+		description.WriteString(" - ")
+		description.WriteString(link.Description)
+	}
+	return description.String()
+}
+
+func IsTextMIMEType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") ||
+		mimeType == "application/json" ||
+		mimeType == "application/xml" ||
+		mimeType == "application/yaml"
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func Test_ToolResultBlocks(t *testing.T) {
+	response := ToolResponse{
+		ToolId: "toolu_01",
+		MCPResponse: &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.NewTextContent("Took a screenshot"),
+				mcp.NewImageContent("iVBORw0KGgo=", "image/png"),
+				mcp.NewImageContent("AAAA", "image/bmp"),
+				mcp.NewResourceLink("file:///tmp/a.txt", "a.txt", "Some file", "text/plain"),
+				mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+					URI:      "file:///tmp/b.json",
+					MIMEType: "application/json",
+					Blob:     base64.StdEncoding.EncodeToString([]byte(`{"a":1}`)),
+				}),
+				mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+					URI:      "file:///tmp/c.pdf",
+					MIMEType: "application/pdf",
+					Blob:     "JVBERi0=",
+				}),
+			},
+		},
+	}
+
+	result, extra := ToolResultBlocks(response)
+	if result.Type != llm.BlockToolResult || result.ToolUseId != "toolu_01" {
+		t.Fatalf("Expected a tool_result block, got %#v", result)
+	}
+
+	content := result.Content
+	if len(content) != 6 {
+		t.Fatalf("Expected 6 content blocks but got %d", len(content))
+	}
+	if content[1].Type != llm.BlockImage {
+		t.Error("PNG images should be sent as images!")
+	}
+	if content[2].Type != llm.BlockText {
+		t.Error("Unsupported images should fall back to text!")
+	}
+	if content[4].Type != llm.BlockText || content[4].Text != "Resource `file:///tmp/b.json`:\n{\"a\":1}" {
+		t.Errorf("JSON blobs should be decoded into text, got %#v", content[4])
+	}
+
+	if len(extra) != 1 || extra[0].Type != llm.BlockDocument {
+		t.Errorf("PDFs should be attached as a document after the tool_result, got %#v", extra)
+	}
+}
@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Lists every tool of an MCP server, already converted for the LLM.
+// Tools with a schema that can't be converted are skipped and logged on `logger` (if not nil).
+func ListServerTools(ctx context.Context, mcpClient *client.Client, config MCPServerConfig, logger *log.Logger) ([]MCPTool, error) {
+	prefix := ToolPrefix(config)
+	tools := []MCPTool{}
+
+	var defaultCursor mcp.Cursor
+	var cursor mcp.Cursor
+	for {
+		svTools, err := ListToolsWithSchemas(ctx, mcpClient, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tool := range svTools.Tools {
+			toolName := NamespacedToolName(prefix, tool.Name)
+			inputSchema, err := ToolInputSchema(tool)
+			if err != nil {
+				orDiscard(logger).Printf("Skipping tool `%s` of `%s`: %s", tool.Name, config.Name, err)
+				continue
+			}
+
+			tools = append(tools, MCPTool{
+				Client:      mcpClient,
+				ServerName:  config.Name,
+				Name:        tool.Name,
+				ExposedName: toolName,
+				Definition: llm.Tool{
+					Name:        toolName,
+					Description: tool.Description,
+					InputSchema: inputSchema,
+				},
+				Annotations: tool.Annotations,
+			})
+		}
+
+		if svTools.NextCursor == defaultCursor {
+			break // No more pages
+		}
+		cursor = svTools.NextCursor
+	}
+
+	return tools, nil
+}
+
+// Every tool the LLM can use, safe to use from many goroutines.
+type ToolRegistry struct {
+	mutex       sync.RWMutex
+	definitions []llm.Tool
+	toolsByName map[string]MCPTool
+	// Closed (and replaced) whenever the tools change.
+	changed chan struct{}
+	// Nothing is logged if nil.
+	Logger *log.Logger
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{toolsByName: make(map[string]MCPTool), changed: make(chan struct{})}
+}
+
+// Replaces every tool of `serverName` with `tools`.
+func (registry *ToolRegistry) SetServerTools(serverName string, tools []MCPTool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	for name, tool := range registry.toolsByName {
+		if tool.ServerName == serverName {
+			delete(registry.toolsByName, name)
+		}
+	}
+
+	for _, tool := range tools {
+		if existing, found := registry.toolsByName[tool.ExposedName]; found {
+			orDiscard(registry.Logger).Printf("Skipping tool `%s` of `%s`: the name `%s` is already used by `%s`", tool.Name, serverName, tool.ExposedName, existing.ServerName)
+			continue
+		}
+		orDiscard(registry.Logger).Printf("Adding tool: %s (%s)", tool.ExposedName, tool.Name)
+		registry.toolsByName[tool.ExposedName] = tool
+	}
+
+	// A new slice is built since the previous one may still be in use by a request to the LLM.
+	definitions := make([]llm.Tool, 0, len(registry.toolsByName))
+	for _, definition := range registry.definitions {
+		if tool, found := registry.toolsByName[definition.Name]; found && tool.ServerName != serverName {
+			definitions = append(definitions, definition)
+		}
+	}
+	for _, tool := range tools {
+		// Only the tools that weren't skipped because of a name collision.
+		if registered := registry.toolsByName[tool.ExposedName]; registered.ServerName == serverName && registered.Name == tool.Name {
+			definitions = append(definitions, tool.Definition)
+		}
+	}
+	registry.definitions = definitions
+	close(registry.changed)
+	registry.changed = make(chan struct{})
+}
+
+// Definitions of every tool, in the order they were added.
+// The slice must not be modified.
+func (registry *ToolRegistry) Definitions() []llm.Tool {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	return registry.definitions
+}
+
+// Finds a tool by the name the LLM knows it by.
+func (registry *ToolRegistry) Find(exposedName string) (MCPTool, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	tool, found := registry.toolsByName[exposedName]
+	return tool, found
+}
+
+// Waits until the server of `tool` reconnects, returning the tool of the new connection.
+// Returns false if the context is done first.
+func (registry *ToolRegistry) WaitForReconnection(ctx context.Context, tool MCPTool) (MCPTool, bool) {
+	for {
+		registry.mutex.RLock()
+		current, found := registry.toolsByName[tool.ExposedName]
+		changed := registry.changed
+		registry.mutex.RUnlock()
+		if found && current.Client != tool.Client {
+			return current, true
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return MCPTool{}, false
+		}
+	}
+}
+
+type ToolResponse struct {
+	IsError     bool
+	MCPResponse *mcp.CallToolResult
+	ToolId      string
+	// Why the call failed before the server responded, if it did.
+	Err error
+}
+
+// Builds the response of a tool call that never reached the MCP server or failed on the way.
+// The LLM still needs a tool_result for every tool_use, so the error is reported as one.
+func NewToolErrorResponse(toolId string, format string, a ...any) ToolResponse {
+	return ToolResponse{
+		IsError:     true,
+		MCPResponse: mcp.NewToolResultErrorf(format, a...),
+		ToolId:      toolId,
+	}
+}
+
+// Whether a call failed only because the connection to the server was lost,
+// so it can be retried once the server reconnects.
+func (response ToolResponse) ConnectionLost() bool {
+	return errors.Is(response.Err, ErrConnectionLost)
+}
+
+// Calls the tool the LLM requested on a tool_use block, logging it on `logger` (if not nil).
+// Any failure is reported as an error response, never returned.
+func CallTool(ctx context.Context, tool MCPTool, toolInfo llm.Block, logger *log.Logger) ToolResponse {
+	logger = orDiscard(logger)
+	ctx, cancelCtx := context.WithTimeout(ctx, TOOL_CALL_TIMEOUT)
+	defer cancelCtx()
+
+	params := map[string]any{}
+	if len(toolInfo.Input) > 0 {
+		err := json.Unmarshal(toolInfo.Input, &params)
+		if err != nil {
+			logger.Printf("Failed to unmarshall into a map: %s\n%s", err, string(toolInfo.Input))
+			return NewToolErrorResponse(toolInfo.ToolUseId, "Error: tool input must be a JSON object: %s", err)
+		}
+	}
+
+	logger.Printf("Calling tool `%s` of `%s` with: %#v", tool.Name, tool.ServerName, params)
+	resp, err := tool.Client.CallTool(ctx, mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Name:      tool.Name,
+			Arguments: params,
+		},
+	})
+	if err != nil {
+		logger.Println("ERROR: Failed to call tool:", err)
+		response := NewToolErrorResponse(toolInfo.ToolUseId, "Error: failed to call tool `%s`: %s", tool.Name, err)
+		response.Err = err
+		return response
+	}
+	logger.Printf("Tool `%s` responded with: %#v", toolInfo.ToolName, resp)
+
+	return ToolResponse{
+		IsError:     resp.IsError,
+		MCPResponse: resp,
+		ToolId:      toolInfo.ToolUseId,
+	}
+}
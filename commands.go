@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Runs a slash command typed by the user.
+func (m model) runCommand(input string) (model, tea.Cmd) {
+	name, args, _ := strings.Cut(strings.TrimSpace(input), " ")
+	args = strings.TrimSpace(args)
+
+	switch name {
+	case "/model":
+		if args == "" {
+			m.showDisplay(DISPLAYS.Help)
+			return m, nil
+		}
+
+		m.modelConfig.Id = m.modelConfig.ResolveModel(args)
+		m.session.SetDefaults(m.requestDefaults())
+		LOG.Printf("Switched to model `%s`", m.modelConfig.Id)
+		m.err = nil
+		return m, nil
+
+	case "/compact":
+		if m.session.Running() {
+			m.err = fmt.Errorf("wait for the current response before compacting")
+			return m, nil
+		}
+
+		m.err = nil
+		m.aiThinking = true
+		m.saveSession()
+		m.showDisplay(DISPLAYS.Chat)
+		return m, compactSession(m.programCtx, m.session)
+
+	case "/sessions":
+		m.showDisplay(DISPLAYS.Sessions)
+		return m, nil
+
+	case "/servers":
+		m.showDisplay(DISPLAYS.Servers)
+		return m, nil
+
+	case "/resume", "/fork":
+		if m.session.Running() {
+			m.err = fmt.Errorf("wait for the current response before switching sessions")
+			return m, nil
+		}
+
+		id := args
+		if name == "/fork" {
+			source := args
+			if source == "" {
+				m.saveSession()
+				source = m.sessionId
+			}
+			forkId, err := m.sessionStore.Fork(source)
+			if err != nil {
+				m.err = err
+				return m, nil
+			}
+			LOG.Printf("Forked session `%s` into `%s`", source, forkId)
+			id = forkId
+		}
+		if id == "" {
+			m.showDisplay(DISPLAYS.Sessions)
+			return m, nil
+		}
+
+		if err := m.resumeSession(id); err != nil {
+			m.err = err
+			return m, nil
+		}
+		m.err = nil
+		m.showDisplay(DISPLAYS.Chat)
+		return m, nil
+
+	case "/delete":
+		if args == m.sessionId {
+			m.err = fmt.Errorf("the current session can't be deleted")
+			return m, nil
+		}
+		if err := m.sessionStore.Delete(args); err != nil {
+			m.err = err
+			return m, nil
+		}
+		LOG.Printf("Deleted session `%s`", args)
+		m.err = nil
+		m.showDisplay(DISPLAYS.Sessions)
+		return m, nil
+	}
+
+	idx := slices.IndexFunc(m.prompts, func(prompt MCPPrompt) bool { return prompt.Command == name })
+	if idx == -1 {
+		m.err = fmt.Errorf("unknown command `%s`", name)
+		return m, nil
+	}
+
+	prompt := m.prompts[idx]
+	if len(prompt.Prompt.Arguments) == 0 {
+		return m, getPrompt(m.programCtx, prompt, nil)
+	}
+
+	m.promptForm = &PromptForm{
+		Prompt: prompt,
+		Values: make(map[string]string),
+	}
+	m.textarea.Placeholder = m.promptForm.Placeholder()
+	return m, nil
+}
+
+// Stores the textarea value as the current prompt argument,
+// getting the prompt once every argument has been filled.
+func (m model) submitPromptArgument(value string) (model, tea.Cmd) {
+	done, err := m.promptForm.Submit(value)
+	if err != nil {
+		m.err = err
+		return m, nil
+	}
+	m.err = nil
+
+	if !done {
+		m.textarea.Placeholder = m.promptForm.Placeholder()
+		return m, nil
+	}
+
+	form := m.promptForm
+	m.promptForm = nil
+	m.textarea.Placeholder = TEXTAREA_PLACEHOLDER
+	return m, getPrompt(m.programCtx, form.Prompt, form.Values)
+}
+
+// Commands that are always available, MCP prompts are added to these.
+var BUILTIN_COMMANDS = []string{"/model", "/sessions", "/resume", "/fork", "/delete", "/compact", "/servers"}
+
+// Slash commands that start with what the user has typed so far.
+func (m model) MatchingCommands(input string) []string {
+	matches := []string{}
+	for _, command := range BUILTIN_COMMANDS {
+		if strings.HasPrefix(command, input) {
+			matches = append(matches, command)
+		}
+	}
+	for _, prompt := range m.prompts {
+		if strings.HasPrefix(prompt.Command, input) {
+			matches = append(matches, prompt.Command)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+type OutputFormat string
+
+var OUTPUT_FORMATS = struct {
+	Text OutputFormat
+	Json OutputFormat
+}{
+	Text: "text",
+	Json: "json",
+}
+
+// Exit codes of the headless mode.
+const (
+	EXIT_SUCCESS = 0
+	// The agent loop failed, like an API error or running out of turns.
+	EXIT_FAILURE = 1
+	// The command line arguments are invalid.
+	EXIT_USAGE = 2
+)
+
+// A tool call made while running headless.
+type HeadlessToolCall struct {
+	Id      string          `json:"id"`
+	Name    string          `json:"name"`
+	Server  string          `json:"server,omitempty"`
+	Input   json.RawMessage `json:"input"`
+	IsError bool            `json:"is_error"`
+	// Text content of the tool result.
+	Output string `json:"output"`
+}
+
+// What the headless mode prints when using the JSON output format.
+type HeadlessResult struct {
+	Result     string             `json:"result"`
+	IsError    bool               `json:"is_error"`
+	Error      string             `json:"error,omitempty"`
+	SessionId  string             `json:"session_id"`
+	Model      string             `json:"model"`
+	StopReason llm.StopReason     `json:"stop_reason,omitempty"`
+	Turns      int                `json:"turns"`
+	ToolCalls  []HeadlessToolCall `json:"tool_calls"`
+	Usage      llm.Usage          `json:"usage"`
+	// Estimated with the prices of the config, 0 if the model has no known prices.
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// Reads the prompt given to `-p`, `-` means the prompt comes from stdin.
+func HeadlessPrompt(prompt string, stdin io.Reader) (string, error) {
+	if prompt != "-" {
+		return prompt, nil
+	}
+
+	contents, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read prompt from stdin: %w", err)
+	}
+	if strings.TrimSpace(string(contents)) == "" {
+		return "", fmt.Errorf("the prompt read from stdin is empty")
+	}
+	return string(contents), nil
+}
+
+// Runs the agent loop without a TUI until the LLM stops calling tools.
+// Returns the exit code of the process.
+func runHeadless(ctx context.Context, m model, prompt string, format OutputFormat, maxTurns int, out io.Writer) int {
+	if format != OUTPUT_FORMATS.Text && format != OUTPUT_FORMATS.Json {
+		fmt.Fprintf(os.Stderr, "Unknown output format `%s`, use `text` or `json`\n", format)
+		return EXIT_USAGE
+	}
+
+	// Nobody can approve sampling requests without a TUI.
+	go func() {
+		for {
+			select {
+			case request := <-m.samplingRequests:
+				LOG.Printf("Rejecting sampling request from `%s` in headless mode", request.ServerName)
+				request.Decide(false)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	result := HeadlessResult{SessionId: m.sessionId, ToolCalls: []HeadlessToolCall{}}
+	err := m.runAgentLoop(ctx, prompt, maxTurns, &result)
+	m.saveSession()
+
+	if err != nil {
+		LOG.Println("Headless run failed:", err)
+		result.IsError = true
+		result.Error = err.Error()
+	}
+
+	if format == OUTPUT_FORMATS.Json {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to print result:", err)
+			return EXIT_FAILURE
+		}
+	} else {
+		if result.Result != "" {
+			fmt.Fprintln(out, result.Result)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+		}
+	}
+
+	if result.IsError {
+		return EXIT_FAILURE
+	}
+	return EXIT_SUCCESS
+}
+
+// Sends the prompt to the agent session, collecting its events into `result`.
+func (m *model) runAgentLoop(ctx context.Context, prompt string, maxTurns int, result *HeadlessResult) error {
+	m.session.MaxTurns = maxTurns
+	events, err := m.session.Send(ctx, prompt)
+	if err != nil {
+		return err
+	}
+
+	var runErr error
+	for event := range events {
+		switch event.Type {
+		case agent.EVENT_TYPES.Message:
+			m.saveSession()
+			if event.Message.Role != llm.RoleAssistant {
+				continue
+			}
+
+			text := strings.Builder{}
+			for _, block := range event.Message.Content {
+				if block.Type == llm.BlockText {
+					text.WriteString(block.Text)
+				}
+			}
+			result.Result = text.String()
+
+		case agent.EVENT_TYPES.Usage:
+			result.Turns++
+			result.Model = event.Model
+			result.StopReason = event.StopReason
+			result.Usage.Add(event.Usage)
+			m.usage.Add(event.Model, event.Usage)
+			result.CostUSD = m.usage.Cost
+
+		case agent.EVENT_TYPES.Compaction:
+			m.saveCompactedSession()
+
+		case agent.EVENT_TYPES.ToolResult:
+			output := []string{}
+			for _, resultBlock := range event.ToolResult.Content {
+				if resultBlock.Type == llm.BlockText {
+					output = append(output, resultBlock.Text)
+				}
+			}
+			input := event.ToolUse.Input
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+			result.ToolCalls = append(result.ToolCalls, HeadlessToolCall{
+				Id:      event.ToolUse.ToolUseId,
+				Name:    event.ToolUse.ToolName,
+				Server:  event.Tool.ServerName,
+				Input:   input,
+				IsError: event.ToolResponse.IsError,
+				Output:  strings.Join(output, "\n"),
+			})
+
+		case agent.EVENT_TYPES.Error:
+			runErr = event.Err
+		}
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+	if result.StopReason == llm.StopReasonMaxTokens {
+		return fmt.Errorf("the response was cut off after reaching the max tokens")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+// Replies with the given responses in order.
+type fakeProvider struct {
+	responses []llm.Response
+	requests  []llm.Request
+}
+
+func (provider *fakeProvider) Name() string {
+	return "Fake"
+}
+
+func (provider *fakeProvider) Stream(ctx context.Context, request llm.Request, onEvent func(llm.StreamEvent)) (*llm.Response, error) {
+	provider.requests = append(provider.requests, request)
+	response := provider.responses[0]
+	provider.responses = provider.responses[1:]
+	return &response, nil
+}
+
+func Test_RunHeadless(t *testing.T) {
+	LOG = log.New(io.Discard, "", 0)
+	provider := &fakeProvider{responses: []llm.Response{
+		{
+			Message:    llm.NewAssistantMessage(llm.NewToolUseBlock("toolu_01", "gh__search", json.RawMessage(`{"query":"mcp"}`))),
+			StopReason: llm.StopReasonToolUse,
+			Usage:      llm.Usage{InputTokens: 10, OutputTokens: 5},
+		},
+		{
+			Message:    llm.NewAssistantMessage(llm.NewTextBlock("Nothing found.")),
+			StopReason: llm.StopReasonEndTurn,
+			Model:      "fake-1",
+			Usage:      llm.Usage{InputTokens: 20, OutputTokens: 3},
+		},
+	}}
+	m := model{
+		session:          agent.NewSession(provider, agent.NewToolRegistry(), llm.Request{}),
+		samplingRequests: make(chan SamplingRequest),
+		sessionStore:     SessionStore{Dir: t.TempDir()},
+		sessionId:        NewSessionId(),
+	}
+
+	out := bytes.Buffer{}
+	exitCode := runHeadless(context.Background(), m, "Search mcp", OUTPUT_FORMATS.Json, 5, &out)
+	if exitCode != EXIT_SUCCESS {
+		t.Fatalf("Expected a successful exit code, got %d", exitCode)
+	}
+
+	var result HeadlessResult
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON output: %s\n%s", err, out.String())
+	}
+	if result.Result != "Nothing found." || result.Turns != 2 || result.Model != "fake-1" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if result.Usage.InputTokens != 30 || result.Usage.OutputTokens != 8 {
+		t.Errorf("Usage should be added up, got %+v", result.Usage)
+	}
+	if len(result.ToolCalls) != 1 || !result.ToolCalls[0].IsError || !strings.Contains(result.ToolCalls[0].Output, "doesn't exist") {
+		t.Errorf("Unexpected tool calls: %+v", result.ToolCalls)
+	}
+
+	toolResult := provider.requests[1].Messages[2].Content[0]
+	if toolResult.Type != llm.BlockToolResult || toolResult.ToolUseId != "toolu_01" {
+		t.Errorf("The tool result should be sent back, got %#v", toolResult)
+	}
+
+	messages, err := m.sessionStore.Load(m.sessionId)
+	if err != nil || len(messages) != 4 {
+		t.Errorf("Expected the 4 messages to be saved, got %d %s", len(messages), err)
+	}
+
+	provider.responses = []llm.Response{{
+		Message:    llm.NewAssistantMessage(llm.NewTextBlock("Cut")),
+		StopReason: llm.StopReasonMaxTokens,
+	}}
+	out.Reset()
+	if exitCode := runHeadless(context.Background(), m, "Again", OUTPUT_FORMATS.Text, 5, &out); exitCode != EXIT_FAILURE {
+		t.Errorf("Expected a failure exit code for a cut off response, got %d", exitCode)
+	}
+	if out.String() != "Cut\n" {
+		t.Errorf("Unexpected text output: `%s`", out.String())
+	}
+}
@@ -0,0 +1,323 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	ant "github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// Talks to Claude through the Anthropic Messages API.
+type AnthropicProvider struct {
+	client ant.Client
+	logger *log.Logger
+}
+
+func NewAnthropicProvider(apiKey string, logger *log.Logger) *AnthropicProvider {
+	return &AnthropicProvider{
+		client: ant.NewClient(option.WithAPIKey(apiKey)),
+		logger: logger,
+	}
+}
+
+func (provider *AnthropicProvider) Name() string {
+	return "Claude"
+}
+
+func (provider *AnthropicProvider) Stream(ctx context.Context, request Request, onEvent func(StreamEvent)) (*Response, error) {
+	params := AnthropicParams(request)
+
+	opts := []option.RequestOption{}
+	if provider.logger != nil {
+		opts = append(opts, option.WithDebugLog(provider.logger))
+	}
+	stream := provider.client.Messages.NewStreaming(ctx, params, opts...)
+	defer stream.Close()
+
+	message := ant.Message{}
+	for stream.Next() {
+		event := stream.Current()
+		if err := message.Accumulate(event); err != nil {
+			return nil, err
+		}
+
+		if onEvent != nil {
+			if streamEvent, ok := anthropicStreamEvent(event); ok {
+				onEvent(streamEvent)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+
+	return anthropicResponse(message), nil
+}
+
+// Counts the input tokens of a request with the token counting endpoint.
+func (provider *AnthropicProvider) CountTokens(ctx context.Context, request Request) (int64, error) {
+	params := AnthropicParams(request)
+	countParams := ant.MessageCountTokensParams{
+		Model:      params.Model,
+		Messages:   params.Messages,
+		ToolChoice: params.ToolChoice,
+	}
+	if len(params.System) > 0 {
+		countParams.System.OfTextBlockArray = params.System
+	}
+	for _, tool := range params.Tools {
+		countParams.Tools = append(countParams.Tools, ant.MessageCountTokensToolUnionParam{OfTool: tool.OfTool})
+	}
+
+	count, err := provider.client.Messages.CountTokens(ctx, countParams)
+	if err != nil {
+		return 0, err
+	}
+	return count.InputTokens, nil
+}
+
+// Converts a request into the params of the Anthropic SDK.
+func AnthropicParams(request Request) ant.MessageNewParams {
+	params := ant.MessageNewParams{
+		Model:         ant.Model(request.Model),
+		MaxTokens:     request.MaxTokens,
+		Messages:      make([]ant.MessageParam, 0, len(request.Messages)),
+		StopSequences: request.StopSequences,
+	}
+
+	if request.System != "" {
+		params.System = []ant.TextBlockParam{{Text: request.System}}
+	}
+	if request.Temperature != nil {
+		params.Temperature = ant.Float(*request.Temperature)
+	}
+	if request.TopP != nil {
+		params.TopP = ant.Float(*request.TopP)
+	}
+
+	for _, tool := range request.Tools {
+		toolParam := ant.ToolParam{
+			Name:        tool.Name,
+			InputSchema: anthropicInputSchema(tool.InputSchema),
+		}
+		if tool.Description != "" {
+			toolParam.Description = ant.String(tool.Description)
+		}
+		params.Tools = append(params.Tools, ant.ToolUnionParam{OfTool: &toolParam})
+	}
+	// Claude rejects a tool choice when there are no tools.
+	if len(params.Tools) > 0 {
+		params.ToolChoice = anthropicToolChoice(request.ToolChoice)
+	}
+
+	if request.Cache.Tools && len(params.Tools) > 0 {
+		*params.Tools[len(params.Tools)-1].GetCacheControl() = ant.NewCacheControlEphemeralParam()
+	}
+	if request.Cache.System && len(params.System) > 0 {
+		params.System[0].CacheControl = ant.NewCacheControlEphemeralParam()
+	}
+
+	for _, msg := range request.Messages {
+		msgParam := ant.MessageParam{
+			Role:    ant.MessageParamRole(msg.Role),
+			Content: make([]ant.ContentBlockParamUnion, 0, len(msg.Content)),
+		}
+		for _, block := range msg.Content {
+			if blockParam, ok := anthropicBlock(block); ok {
+				msgParam.Content = append(msgParam.Content, blockParam)
+			}
+		}
+		if len(msgParam.Content) > 0 {
+			params.Messages = append(params.Messages, msgParam)
+		}
+	}
+
+	if request.Cache.Messages && len(params.Messages) > 0 {
+		content := params.Messages[len(params.Messages)-1].Content
+		// Thinking blocks can't be cached directly, but they're included by any later block.
+		for i := len(content) - 1; i >= 0; i-- {
+			if cacheControl := content[i].GetCacheControl(); cacheControl != nil {
+				*cacheControl = ant.NewCacheControlEphemeralParam()
+				break
+			}
+		}
+	}
+
+	return params
+}
+
+func anthropicInputSchema(schema map[string]any) ant.ToolInputSchemaParam {
+	inputSchema := ant.ToolInputSchemaParam{
+		Properties:  map[string]any{},
+		ExtraFields: map[string]any{},
+	}
+	for key, value := range schema {
+		switch key {
+		case "type":
+		case "properties":
+			inputSchema.Properties = value
+		case "required":
+			switch required := value.(type) {
+			case []string:
+				inputSchema.Required = required
+			case []any:
+				for _, name := range required {
+					if name, ok := name.(string); ok {
+						inputSchema.Required = append(inputSchema.Required, name)
+					}
+				}
+			}
+		default:
+			inputSchema.ExtraFields[key] = value
+		}
+	}
+	return inputSchema
+}
+
+func anthropicToolChoice(toolChoice string) ant.ToolChoiceUnionParam {
+	switch toolChoice {
+	case "":
+		return ant.ToolChoiceUnionParam{}
+	case "auto":
+		return ant.ToolChoiceUnionParam{OfAuto: &ant.ToolChoiceAutoParam{}}
+	case "any":
+		return ant.ToolChoiceUnionParam{OfAny: &ant.ToolChoiceAnyParam{}}
+	case "none":
+		return ant.ToolChoiceUnionParam{OfNone: &ant.ToolChoiceNoneParam{}}
+	default:
+		return ant.ToolChoiceParamOfTool(toolChoice)
+	}
+}
+
+// Returns false for blocks Claude would reject, like empty text.
+func anthropicBlock(block Block) (ant.ContentBlockParamUnion, bool) {
+	switch block.Type {
+	case BlockText:
+		if block.Text == "" {
+			return ant.ContentBlockParamUnion{}, false
+		}
+		return ant.NewTextBlock(block.Text), true
+
+	case BlockImage:
+		return ant.NewImageBlockBase64(block.MediaType, block.Data), true
+
+	case BlockDocument:
+		var document ant.ContentBlockParamUnion
+		if block.MediaType == "application/pdf" {
+			document = ant.NewDocumentBlock(ant.Base64PDFSourceParam{Data: block.Data})
+		} else {
+			document = ant.NewDocumentBlock(ant.PlainTextSourceParam{Data: block.Data})
+		}
+		if block.Title != "" {
+			document.OfDocument.Title = ant.String(block.Title)
+		}
+		return document, true
+
+	case BlockToolUse:
+		input := block.Input
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		return ant.NewToolUseBlock(block.ToolUseId, input, block.ToolName), true
+
+	case BlockToolResult:
+		content := make([]ant.ToolResultBlockParamContentUnion, 0, len(block.Content))
+		for _, resultBlock := range block.Content {
+			switch resultBlock.Type {
+			case BlockText:
+				content = append(content, ant.ToolResultBlockParamContentUnion{
+					OfText: &ant.TextBlockParam{Text: resultBlock.Text},
+				})
+			case BlockImage:
+				content = append(content, ant.ToolResultBlockParamContentUnion{
+					OfImage: &ant.ImageBlockParam{
+						Source: ant.ImageBlockParamSourceUnion{
+							OfBase64: &ant.Base64ImageSourceParam{
+								Data:      resultBlock.Data,
+								MediaType: ant.Base64ImageSourceMediaType(resultBlock.MediaType),
+							},
+						},
+					},
+				})
+			}
+		}
+		return ant.ContentBlockParamUnion{
+			OfToolResult: &ant.ToolResultBlockParam{
+				ToolUseID: block.ToolUseId,
+				Content:   content,
+				IsError:   ant.Bool(block.IsError),
+			},
+		}, true
+
+	case BlockThinking:
+		// Thinking without a signature is only a placeholder of the TUI.
+		if block.Signature == "" {
+			return ant.ContentBlockParamUnion{}, false
+		}
+		return ant.NewThinkingBlock(block.Signature, block.Text), true
+	}
+
+	return ant.ContentBlockParamUnion{}, false
+}
+
+func anthropicStreamEvent(event ant.MessageStreamEventUnion) (StreamEvent, bool) {
+	switch event.Type {
+	case "content_block_start":
+		start := event.AsContentBlockStart()
+		var block Block
+		switch start.ContentBlock.Type {
+		case "text":
+			block = NewTextBlock(start.ContentBlock.Text)
+		case "tool_use":
+			block = NewToolUseBlock(start.ContentBlock.ID, start.ContentBlock.Name, nil)
+		case "thinking":
+			block = NewThinkingBlock(start.ContentBlock.Thinking, start.ContentBlock.Signature)
+		default:
+			block = NewThinkingBlock("", "")
+		}
+		return StreamEvent{Index: int(start.Index), Start: &block}, true
+
+	case "content_block_delta":
+		delta := event.AsContentBlockDelta()
+		switch delta.Delta.Type {
+		case "text_delta":
+			return StreamEvent{Index: int(delta.Index), Delta: delta.Delta.Text}, true
+		case "input_json_delta":
+			return StreamEvent{Index: int(delta.Index), Delta: delta.Delta.PartialJSON}, true
+		case "thinking_delta":
+			return StreamEvent{Index: int(delta.Index), Delta: delta.Delta.Thinking}, true
+		}
+	}
+
+	return StreamEvent{}, false
+}
+
+// The `As*` conversions of the SDK depend on the raw JSON of each block,
+// so the accumulated fields are read directly instead.
+func anthropicResponse(message ant.Message) *Response {
+	msg := NewAssistantMessage()
+	for _, block := range message.Content {
+		switch block.Type {
+		case "text":
+			msg.Content = append(msg.Content, NewTextBlock(block.Text))
+		case "tool_use":
+			msg.Content = append(msg.Content, NewToolUseBlock(block.ID, block.Name, block.Input))
+		case "thinking":
+			msg.Content = append(msg.Content, NewThinkingBlock(block.Thinking, block.Signature))
+		}
+	}
+
+	return &Response{
+		Message:    msg,
+		StopReason: StopReason(message.StopReason),
+		Model:      string(message.Model),
+		Usage: Usage{
+			InputTokens:              message.Usage.InputTokens,
+			OutputTokens:             message.Usage.OutputTokens,
+			CacheCreationInputTokens: message.Usage.CacheCreationInputTokens,
+			CacheReadInputTokens:     message.Usage.CacheReadInputTokens,
+		},
+	}
+}
@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Claude rejects requests with more cache breakpoints than this.
+const MAX_CACHE_BREAKPOINTS = 4
+
+func Test_AnthropicParams(t *testing.T) {
+	searchTool := Tool{
+		Name:        "gh__search",
+		Description: "Searches GitHub.",
+		InputSchema: map[string]any{
+			"type":                 "object",
+			"properties":           map[string]any{"query": map[string]any{"type": "string"}},
+			"required":             []any{"query"},
+			"additionalProperties": false,
+		},
+	}
+	conversation := []Message{
+		NewUserMessage(NewTextBlock("Search mcp")),
+		NewAssistantMessage(NewToolUseBlock("toolu_01", "gh__search", json.RawMessage(`{"query":"mcp"}`))),
+		NewUserMessage(NewToolResultBlock("toolu_01", []Block{
+			NewTextBlock("A screenshot of the results:"),
+			NewImageBlock("image/png", "iVBORw0KGgo="),
+		}, false)),
+		{Role: RoleAssistant, Content: []Block{
+			NewTextBlock("Found it."),
+			{Type: BlockThinking, Text: "Done.", Signature: "signature"},
+		}},
+	}
+
+	cases := []struct {
+		name    string
+		request Request
+		// Fields of the JSON body, compared as they're decoded.
+		expected    string
+		breakpoints int
+	}{
+		{
+			name:    "tool input schema",
+			request: Request{Model: "claude-sonnet-4-0", Tools: []Tool{searchTool}, ToolChoice: "any"},
+			expected: `{
+				"tools": [{
+					"name": "gh__search",
+					"description": "Searches GitHub.",
+					"input_schema": {
+						"type": "object",
+						"properties": {"query": {"type": "string"}},
+						"required": ["query"],
+						"additionalProperties": false
+					}
+				}],
+				"tool_choice": {"type": "any"}
+			}`,
+		},
+		{
+			name:    "tool result with an image",
+			request: Request{Model: "claude-sonnet-4-0", Messages: conversation[2:3]},
+			expected: `{
+				"messages": [{
+					"role": "user",
+					"content": [{
+						"type": "tool_result",
+						"tool_use_id": "toolu_01",
+						"is_error": false,
+						"content": [
+							{"type": "text", "text": "A screenshot of the results:"},
+							{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+						]
+					}]
+				}]
+			}`,
+		},
+		{
+			name: "cache breakpoints",
+			request: Request{
+				Model:    "claude-sonnet-4-0",
+				System:   "Be brief.",
+				Messages: conversation,
+				Tools:    []Tool{{Name: "gh__issues"}, searchTool},
+				Cache:    CacheOptions{Tools: true, System: true, Messages: true},
+			},
+			expected: `{
+				"system": [{"type": "text", "text": "Be brief.", "cache_control": {"type": "ephemeral"}}],
+				"messages": [
+					{"role": "user", "content": [{"type": "text", "text": "Search mcp"}]},
+					{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_01", "name": "gh__search", "input": {"query": "mcp"}}]},
+					{"role": "user", "content": [{
+						"type": "tool_result",
+						"tool_use_id": "toolu_01",
+						"is_error": false,
+						"content": [
+							{"type": "text", "text": "A screenshot of the results:"},
+							{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+						]
+					}]},
+					{"role": "assistant", "content": [
+						{"type": "text", "text": "Found it.", "cache_control": {"type": "ephemeral"}},
+						{"type": "thinking", "thinking": "Done.", "signature": "signature"}
+					]}
+				]
+			}`,
+			breakpoints: 3,
+		},
+		{
+			name: "no cache breakpoints",
+			request: Request{
+				Model:    "claude-sonnet-4-0",
+				System:   "Be brief.",
+				Messages: conversation,
+				Tools:    []Tool{searchTool},
+			},
+			expected:    `{"system": [{"type": "text", "text": "Be brief."}]}`,
+			breakpoints: 0,
+		},
+	}
+
+	for _, c := range cases {
+		body, err := json.Marshal(AnthropicParams(c.request))
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		var params map[string]any
+		if err := json.Unmarshal(body, &params); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		var expected map[string]any
+		if err := json.Unmarshal([]byte(c.expected), &expected); err != nil {
+			t.Fatalf("%s: invalid expected JSON: %s", c.name, err)
+		}
+
+		for field, value := range expected {
+			if !reflect.DeepEqual(params[field], value) {
+				t.Errorf("%s: unexpected `%s`:\n%#v\nexpected:\n%#v", c.name, field, params[field], value)
+			}
+		}
+
+		breakpoints := strings.Count(string(body), `"cache_control"`)
+		if breakpoints != c.breakpoints || breakpoints > MAX_CACHE_BREAKPOINTS {
+			t.Errorf("%s: expected %d cache breakpoints, got %d", c.name, c.breakpoints, breakpoints)
+		}
+	}
+
+	// Only the last tool is a breakpoint, which caches every tool.
+	params := AnthropicParams(Request{Tools: []Tool{{Name: "gh__issues"}, searchTool}, Cache: CacheOptions{Tools: true}})
+	if params.Tools[0].GetCacheControl().Type != "" || params.Tools[1].GetCacheControl().Type != "ephemeral" {
+		t.Errorf("Expected only the last tool to be cached: %#v", params.Tools)
+	}
+}
@@ -0,0 +1,231 @@
+// Provider agnostic representation of conversations with an LLM.
+package llm
+
+import (
+	"context"
+	"encoding/json"
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+type BlockType string
+
+const (
+	BlockText       BlockType = "text"
+	BlockImage      BlockType = "image"
+	BlockDocument   BlockType = "document"
+	BlockToolUse    BlockType = "tool_use"
+	BlockToolResult BlockType = "tool_result"
+	BlockThinking   BlockType = "thinking"
+)
+
+type StopReason string
+
+const (
+	StopReasonEndTurn      StopReason = "end_turn"
+	StopReasonToolUse      StopReason = "tool_use"
+	StopReasonMaxTokens    StopReason = "max_tokens"
+	StopReasonStopSequence StopReason = "stop_sequence"
+)
+
+// A piece of content of a message.
+// Only the fields relevant to its `Type` are set.
+type Block struct {
+	Type BlockType `json:"type"`
+	// Text of text and thinking blocks.
+	Text string `json:"text,omitempty"`
+
+	// MIME type of images and documents.
+	MediaType string `json:"media_type,omitempty"`
+	// Base64 data of images and PDF documents, raw text of plain text documents.
+	Data  string `json:"data,omitempty"`
+	Title string `json:"title,omitempty"`
+
+	// ID of the tool use, set on both tool uses and their results.
+	ToolUseId string          `json:"tool_use_id,omitempty"`
+	ToolName  string          `json:"tool_name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+
+	// Content of tool results, only text and images.
+	Content []Block `json:"content,omitempty"`
+	IsError bool    `json:"is_error,omitempty"`
+
+	// Signature of thinking blocks.
+	Signature string `json:"signature,omitempty"`
+}
+
+type Message struct {
+	Role    Role    `json:"role"`
+	Content []Block `json:"content"`
+}
+
+type Tool struct {
+	Name        string
+	Description string
+	// JSON Schema of the tool input, its root is always an object.
+	InputSchema map[string]any
+}
+
+type Usage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens,omitempty"`
+}
+
+// Adds the tokens of `other` to this usage.
+func (usage *Usage) Add(other Usage) {
+	usage.InputTokens += other.InputTokens
+	usage.OutputTokens += other.OutputTokens
+	usage.CacheCreationInputTokens += other.CacheCreationInputTokens
+	usage.CacheReadInputTokens += other.CacheReadInputTokens
+}
+
+// Every token the request and response used, which is the size of the context afterwards.
+func (usage Usage) TotalTokens() int64 {
+	return usage.InputTokens + usage.OutputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+}
+
+type Request struct {
+	Model         string
+	System        string
+	Messages      []Message
+	Tools         []Tool
+	MaxTokens     int64
+	Temperature   *float64
+	TopP          *float64
+	StopSequences []string
+	// One of `auto`, `any`, `none` or the name of a tool. Empty uses the provider's default.
+	ToolChoice string
+	Cache      CacheOptions
+}
+
+// Parts of a request the provider is asked to cache, so the following requests reuse them.
+// Only Anthropic needs them, OpenAI caches requests on its own.
+type CacheOptions struct {
+	Tools  bool
+	System bool
+	// Caches the conversation up to its last message, which the next request starts with.
+	Messages bool
+}
+
+type Response struct {
+	Message    Message
+	StopReason StopReason
+	Model      string
+	Usage      Usage
+}
+
+// A piece of a response that's still being generated.
+type StreamEvent struct {
+	// Index of the block of the message this event belongs to.
+	Index int
+	// Set when a new block starts.
+	Start *Block
+	// Text (or partial JSON input for tool uses) appended to the block.
+	Delta string
+}
+
+// An LLM API the host can converse with.
+type Provider interface {
+	// Name shown to the user as the author of the responses.
+	Name() string
+	// Generates a response, calling `onEvent` (if not nil) as it's being streamed.
+	Stream(ctx context.Context, request Request, onEvent func(StreamEvent)) (*Response, error)
+}
+
+// A provider that can count the tokens of a request without generating a response.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, request Request) (int64, error)
+}
+
+// Rough amount of characters per token, used when the provider can't count them.
+const CHARS_PER_TOKEN = 4
+
+// Rough amount of tokens of an image or document, since their data says little about it.
+const ATTACHMENT_TOKENS = 1600
+
+// Estimates the tokens of a request for providers that can't count them.
+func EstimateTokens(request Request) int64 {
+	chars := len(request.System)
+	for _, tool := range request.Tools {
+		schema, _ := json.Marshal(tool.InputSchema)
+		chars += len(tool.Name) + len(tool.Description) + len(schema)
+	}
+
+	var attachments int64
+	var countBlocks func(blocks []Block)
+	countBlocks = func(blocks []Block) {
+		for _, block := range blocks {
+			switch block.Type {
+			case BlockImage, BlockDocument:
+				attachments++
+			default:
+				chars += len(block.Text) + len(block.Input) + len(block.ToolName)
+				countBlocks(block.Content)
+			}
+		}
+	}
+	for _, msg := range request.Messages {
+		countBlocks(msg.Content)
+	}
+
+	return int64(chars/CHARS_PER_TOKEN) + attachments*ATTACHMENT_TOKENS
+}
+
+func NewTextBlock(text string) Block {
+	return Block{Type: BlockText, Text: text}
+}
+
+func NewImageBlock(mediaType string, data string) Block {
+	return Block{Type: BlockImage, MediaType: mediaType, Data: data}
+}
+
+func NewDocumentBlock(mediaType string, data string, title string) Block {
+	return Block{Type: BlockDocument, MediaType: mediaType, Data: data, Title: title}
+}
+
+func NewToolUseBlock(id string, name string, input json.RawMessage) Block {
+	return Block{Type: BlockToolUse, ToolUseId: id, ToolName: name, Input: input}
+}
+
+func NewToolResultBlock(toolUseId string, content []Block, isError bool) Block {
+	return Block{Type: BlockToolResult, ToolUseId: toolUseId, Content: content, IsError: isError}
+}
+
+func NewThinkingBlock(text string, signature string) Block {
+	return Block{Type: BlockThinking, Text: text, Signature: signature}
+}
+
+func NewUserMessage(blocks ...Block) Message {
+	return Message{Role: RoleUser, Content: blocks}
+}
+
+func NewAssistantMessage(blocks ...Block) Message {
+	return Message{Role: RoleAssistant, Content: blocks}
+}
+
+// Applies a stream event to a message being generated.
+func (msg *Message) Accumulate(event StreamEvent) {
+	if event.Start != nil {
+		for len(msg.Content) <= event.Index {
+			msg.Content = append(msg.Content, Block{})
+		}
+		msg.Content[event.Index] = *event.Start
+	}
+	if event.Delta == "" || event.Index >= len(msg.Content) {
+		return
+	}
+
+	block := &msg.Content[event.Index]
+	if block.Type == BlockToolUse {
+		block.Input = append(block.Input, event.Delta...)
+	} else {
+		block.Text += event.Delta
+	}
+}
@@ -0,0 +1,31 @@
+package llm
+
+import "testing"
+
+func Test_Accumulate(t *testing.T) {
+	msg := NewAssistantMessage()
+	toolUse := NewToolUseBlock("toolu_01", "gh__search", nil)
+	text := NewTextBlock("")
+
+	events := []StreamEvent{
+		{Index: 0, Start: &text},
+		{Index: 0, Delta: "Let me "},
+		{Index: 0, Delta: "search."},
+		{Index: 1, Start: &toolUse},
+		{Index: 1, Delta: `{"query":`},
+		{Index: 1, Delta: `"mcp"}`},
+	}
+	for _, event := range events {
+		msg.Accumulate(event)
+	}
+
+	if len(msg.Content) != 2 {
+		t.Fatalf("Expected 2 blocks but got %d", len(msg.Content))
+	}
+	if msg.Content[0].Text != "Let me search." {
+		t.Errorf("Unexpected text: `%s`", msg.Content[0].Text)
+	}
+	if string(msg.Content[1].Input) != `{"query":"mcp"}` {
+		t.Errorf("Unexpected tool input: `%s`", msg.Content[1].Input)
+	}
+}
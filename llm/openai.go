@@ -0,0 +1,364 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Talks to any server implementing the OpenAI chat completions API,
+// like OpenAI itself, Ollama or llama.cpp.
+type OpenAIProvider struct {
+	// Base URL of the API, like `https://api.openai.com/v1` or `http://localhost:11434/v1`.
+	baseURL string
+	// Optional, local servers usually don't need one.
+	apiKey     string
+	httpClient *http.Client
+	logger     *log.Logger
+}
+
+func NewOpenAIProvider(baseURL string, apiKey string, logger *log.Logger) *OpenAIProvider {
+	return &OpenAIProvider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: http.DefaultClient,
+		logger:     logger,
+	}
+}
+
+func (provider *OpenAIProvider) Name() string {
+	return "Assistant"
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    any              `json:"content,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIToolCall struct {
+	// Only set while streaming.
+	Index    *int   `json:"index,omitempty"`
+	Id       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+type openAIRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	Tools         []openAITool    `json:"tools,omitempty"`
+	ToolChoice    any             `json:"tool_choice,omitempty"`
+	MaxTokens     int64           `json:"max_tokens,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
+	Stop          []string        `json:"stop,omitempty"`
+	Stream        bool            `json:"stream"`
+	StreamOptions map[string]any  `json:"stream_options,omitempty"`
+}
+
+type openAIChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens        int64 `json:"prompt_tokens"`
+		CompletionTokens    int64 `json:"completion_tokens"`
+		PromptTokensDetails *struct {
+			CachedTokens int64 `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (provider *OpenAIProvider) Stream(ctx context.Context, request Request, onEvent func(StreamEvent)) (*Response, error) {
+	body, err := json.Marshal(openAIRequestBody(request))
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if provider.apiKey != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+provider.apiKey)
+	}
+
+	if provider.logger != nil {
+		provider.logger.Printf("Sending chat completion request to `%s`", provider.baseURL)
+	}
+	httpResponse, err := provider.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(httpResponse.Body)
+		return nil, fmt.Errorf("chat completion failed with status %d: %s", httpResponse.StatusCode, string(errBody))
+	}
+
+	return readOpenAIStream(httpResponse.Body, onEvent)
+}
+
+// Converts a request into the body of a streamed chat completion.
+func openAIRequestBody(request Request) openAIRequest {
+	body := openAIRequest{
+		Model:         request.Model,
+		MaxTokens:     request.MaxTokens,
+		Temperature:   request.Temperature,
+		TopP:          request.TopP,
+		Stop:          request.StopSequences,
+		Stream:        true,
+		StreamOptions: map[string]any{"include_usage": true},
+	}
+
+	if request.System != "" {
+		body.Messages = append(body.Messages, openAIMessage{Role: "system", Content: request.System})
+	}
+	for _, msg := range request.Messages {
+		body.Messages = append(body.Messages, openAIMessages(msg)...)
+	}
+
+	for _, tool := range request.Tools {
+		openTool := openAITool{Type: "function"}
+		openTool.Function.Name = tool.Name
+		openTool.Function.Description = tool.Description
+		openTool.Function.Parameters = tool.InputSchema
+		body.Tools = append(body.Tools, openTool)
+	}
+	if len(body.Tools) > 0 {
+		switch request.ToolChoice {
+		case "":
+		case "auto", "none":
+			body.ToolChoice = request.ToolChoice
+		case "any":
+			body.ToolChoice = "required"
+		default:
+			body.ToolChoice = map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": request.ToolChoice},
+			}
+		}
+	}
+
+	return body
+}
+
+// A single message may become many, since every tool result is its own message.
+func openAIMessages(msg Message) []openAIMessage {
+	messages := []openAIMessage{}
+	parts := []openAIContentPart{}
+	toolCalls := []openAIToolCall{}
+
+	for _, block := range msg.Content {
+		switch block.Type {
+		case BlockText:
+			if block.Text != "" {
+				parts = append(parts, openAIContentPart{Type: "text", Text: block.Text})
+			}
+		case BlockImage:
+			parts = append(parts, openAIImagePart(block))
+		case BlockDocument:
+			if block.MediaType == "application/pdf" {
+				parts = append(parts, openAIContentPart{Type: "text", Text: fmt.Sprintf("[PDF document `%s` can't be read by this model.]", block.Title)})
+			} else {
+				parts = append(parts, openAIContentPart{Type: "text", Text: fmt.Sprintf("Document `%s`:\n%s", block.Title, block.Data)})
+			}
+		case BlockToolUse:
+			call := openAIToolCall{Id: block.ToolUseId, Type: "function"}
+			call.Function.Name = block.ToolName
+			call.Function.Arguments = string(block.Input)
+			if call.Function.Arguments == "" {
+				call.Function.Arguments = "{}"
+			}
+			toolCalls = append(toolCalls, call)
+		case BlockToolResult:
+			text := strings.Builder{}
+			if block.IsError {
+				text.WriteString("Error: ")
+			}
+			for _, resultBlock := range block.Content {
+				if resultBlock.Type == BlockText {
+					text.WriteString(resultBlock.Text)
+					text.WriteString("\n")
+				} else if resultBlock.Type == BlockImage {
+					// Tool messages can only contain text.
+					parts = append(parts, openAIImagePart(resultBlock))
+				}
+			}
+			messages = append(messages, openAIMessage{
+				Role:       "tool",
+				ToolCallId: block.ToolUseId,
+				Content:    strings.TrimSuffix(text.String(), "\n"),
+			})
+		}
+	}
+
+	if msg.Role == RoleAssistant {
+		text := strings.Builder{}
+		for _, part := range parts {
+			text.WriteString(part.Text)
+		}
+		assistantMsg := openAIMessage{Role: "assistant", ToolCalls: toolCalls}
+		if text.Len() > 0 {
+			assistantMsg.Content = text.String()
+		}
+		return append(messages, assistantMsg)
+	}
+
+	if len(parts) > 0 {
+		messages = append(messages, openAIMessage{Role: "user", Content: parts})
+	}
+	return messages
+}
+
+func openAIImagePart(block Block) openAIContentPart {
+	return openAIContentPart{
+		Type:     "image_url",
+		ImageURL: &openAIImageURL{URL: "data:" + block.MediaType + ";base64," + block.Data},
+	}
+}
+
+// Reads the server sent events of a streamed chat completion.
+func readOpenAIStream(body io.Reader, onEvent func(StreamEvent)) (*Response, error) {
+	response := &Response{Message: NewAssistantMessage(), StopReason: StopReasonEndTurn}
+	textIndex := -1
+	// Index of the message block of each tool call index.
+	toolIndexes := map[int]int{}
+	// Message blocks of the tool calls, in the order they started.
+	toolBlocks := []int{}
+
+	emit := func(event StreamEvent) {
+		response.Message.Accumulate(event)
+		if onEvent != nil {
+			onEvent(event)
+		}
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		data, found := strings.CutPrefix(line, "data:")
+		if !found {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("invalid chat completion chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("chat completion failed: %s", chunk.Error.Message)
+		}
+		if chunk.Model != "" {
+			response.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			response.Usage.InputTokens = chunk.Usage.PromptTokens
+			response.Usage.OutputTokens = chunk.Usage.CompletionTokens
+			// Prompt tokens include the cached ones, which Anthropic counts apart.
+			if details := chunk.Usage.PromptTokensDetails; details != nil {
+				response.Usage.InputTokens -= details.CachedTokens
+				response.Usage.CacheReadInputTokens = details.CachedTokens
+			}
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				if textIndex == -1 {
+					textIndex = len(response.Message.Content)
+					block := NewTextBlock("")
+					emit(StreamEvent{Index: textIndex, Start: &block})
+				}
+				emit(StreamEvent{Index: textIndex, Delta: choice.Delta.Content})
+			}
+
+			for _, call := range choice.Delta.ToolCalls {
+				callIndex := 0
+				if call.Index != nil {
+					callIndex = *call.Index
+				}
+				blockIndex, found := toolIndexes[callIndex]
+				if !found {
+					blockIndex = len(response.Message.Content)
+					toolIndexes[callIndex] = blockIndex
+					toolBlocks = append(toolBlocks, blockIndex)
+					block := NewToolUseBlock(call.Id, call.Function.Name, nil)
+					emit(StreamEvent{Index: blockIndex, Start: &block})
+				}
+				if call.Function.Arguments != "" {
+					emit(StreamEvent{Index: blockIndex, Delta: call.Function.Arguments})
+				}
+			}
+
+			switch choice.FinishReason {
+			case "tool_calls":
+				response.StopReason = StopReasonToolUse
+			case "length":
+				response.StopReason = StopReasonMaxTokens
+			case "stop":
+				response.StopReason = StopReasonEndTurn
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Some servers finish with `stop` even when calling tools.
+	if len(toolBlocks) > 0 {
+		response.StopReason = StopReasonToolUse
+	}
+	for _, blockIndex := range toolBlocks {
+		block := &response.Message.Content[blockIndex]
+		if len(block.Input) == 0 {
+			block.Input = json.RawMessage("{}")
+		}
+	}
+
+	return response, nil
+}
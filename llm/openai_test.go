@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_OpenAIStream(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Unexpected path: `%s`", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Unexpected authorization: `%s`", r.Header.Get("Authorization"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		chunks := []string{
+			`{"model":"llama3","choices":[{"delta":{"content":"Let me "}}]}`,
+			`{"model":"llama3","choices":[{"delta":{"content":"search."}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"gh__search","arguments":""}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"query\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"mcp\"}"}}]}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":7}}`,
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(server.URL+"/v1/", "secret", nil)
+	request := Request{
+		Model:  "llama3",
+		System: "Be brief.",
+		Messages: []Message{
+			NewUserMessage(NewTextBlock("Search mcp")),
+			NewAssistantMessage(NewToolUseBlock("call_0", "gh__search", json.RawMessage(`{"query":"go"}`))),
+			NewUserMessage(NewToolResultBlock("call_0", []Block{NewTextBlock("no results")}, false)),
+		},
+		Tools: []Tool{{
+			Name:        "gh__search",
+			InputSchema: map[string]any{"type": "object"},
+		}},
+		MaxTokens:  100,
+		ToolChoice: "any",
+	}
+
+	events := 0
+	response, err := provider.Stream(context.Background(), request, func(StreamEvent) { events++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := body["messages"].([]any)
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 messages but got %d: %v", len(messages), messages)
+	}
+	if role := messages[0].(map[string]any)["role"]; role != "system" {
+		t.Errorf("Expected a system message first but got `%v`", role)
+	}
+	toolMsg := messages[3].(map[string]any)
+	if toolMsg["role"] != "tool" || toolMsg["tool_call_id"] != "call_0" || toolMsg["content"] != "no results" {
+		t.Errorf("Unexpected tool message: %v", toolMsg)
+	}
+	if body["tool_choice"] != "required" {
+		t.Errorf("Unexpected tool choice: %v", body["tool_choice"])
+	}
+
+	if events != 6 {
+		t.Errorf("Expected 6 events but got %d", events)
+	}
+	if response.StopReason != StopReasonToolUse {
+		t.Errorf("Unexpected stop reason: `%s`", response.StopReason)
+	}
+	if response.Model != "llama3" || response.Usage.InputTokens != 12 || response.Usage.OutputTokens != 7 {
+		t.Errorf("Unexpected model or usage: %s %+v", response.Model, response.Usage)
+	}
+	if len(response.Message.Content) != 2 {
+		t.Fatalf("Expected 2 blocks but got %d", len(response.Message.Content))
+	}
+	if response.Message.Content[0].Text != "Let me search." {
+		t.Errorf("Unexpected text: `%s`", response.Message.Content[0].Text)
+	}
+	toolUse := response.Message.Content[1]
+	if toolUse.ToolUseId != "call_1" || string(toolUse.Input) != `{"query":"mcp"}` {
+		t.Errorf("Unexpected tool use: %+v", toolUse)
+	}
+}
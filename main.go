@@ -2,25 +2,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
 	"sync"
-	"time"
 
-	ant "github.com/anthropics/anthropic-sdk-go"
-	"github.com/anthropics/anthropic-sdk-go/option"
-	"github.com/anthropics/anthropic-sdk-go/packages/param"
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/joho/godotenv"
 	"github.com/mark3labs/mcp-go/client"
-	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/pelletier/go-toml/v2"
 )
@@ -28,45 +25,93 @@ import (
 const HELP_CONTENT = `
 F1: Toggle Help
 F2: Toggle Logs
+F3: Toggle Resources
+    Up/Down: Select resource
+    Enter: Attach resource to the next message
+    Delete: Remove all attachments
+Esc: Cancel the prompt being filled in or the response being generated (and its tool calls), quits otherwise
+/<server>:<prompt>: Use a prompt from an MCP server
+y/n: Approve or reject a server's request to use the LLM
+y/a/n: Allow a tool call once, always allow the tool or deny it
+/model <id>: Switch to another model, either an ID or (for Anthropic) haiku, sonnet or opus
+/sessions: List saved sessions
+/resume <id>: Continue a saved session
+/fork [id]: Continue a copy of a session, the current one by default
+/delete <id>: Delete a saved session
+/compact: Summarize the older messages to free up context
+/servers: Show the state of the MCP servers
 `
 
+const WELCOME_CONTENT = "Welcome! Chat to the LLM...\nPress F1 to view help!"
+
+const TEXTAREA_PLACEHOLDER = "Send a message..."
+
 const LOG_FILE = "session.log"
 
 const GAP = "\n\n"
 
-type MCPServerType string
-
-var MCP_SERVERS_TYPE = struct {
-	Http  MCPServerType
-	Stdio MCPServerType
+// What's currently shown on the viewport.
+type Display int
+
+var DISPLAYS = struct {
+	Chat         Display
+	Help         Display
+	Logs         Display
+	Resources    Display
+	Sampling     Display
+	Sessions     Display
+	ToolApproval Display
+	Servers      Display
 }{
-	Http:  "http",
-	Stdio: "stdio",
-}
-
-type MCPServerConfig struct {
-	Name    string
-	Type    MCPServerType
-	URL     string
-	Command string
-	Args    []string
+	Chat:         0,
+	Help:         1,
+	Logs:         2,
+	Resources:    3,
+	Sampling:     4,
+	Sessions:     5,
+	ToolApproval: 6,
+	Servers:      7,
 }
 
 type Config struct {
 	MaxTokens uint
-	Servers   []MCPServerConfig
+	// Directory where conversations are saved, defaults to `sessions`.
+	SessionsDir string
+	// Used for tools no policy matches, defaults to `ask`.
+	DefaultToolPolicy agent.ToolPolicy
+	ToolPolicies      []agent.ToolPolicyRule
+	// Prices and context window of each model, added to `DEFAULT_PRICING`.
+	Pricing    map[string]ModelPricing
+	Compaction agent.CompactionConfig
+	Model      ModelConfig
+	Servers    []agent.MCPServerConfig
 }
 
 var LOG *log.Logger
 
-type ClaudeResponse = *ant.Message
-type ToolResponse struct {
-	IsError     bool
-	MCPResponse *mcp.CallToolResult
-	ToolId      string
+// An event of the agent session currently running.
+type AgentEvent struct {
+	Event  agent.Event
+	events <-chan agent.Event
+}
+
+// The agent session finished running.
+type AgentDone struct{}
+
+// The conversation was compacted by `/compact`.
+type CompactionDone struct {
+	Result agent.CompactionResult
+	Err    error
 }
 
 func main() {
+	resumeId := flag.String("resume", "", "ID of a saved session to continue")
+	prompt := flag.String("p", "", "Run without a TUI, answering this prompt (`-` reads it from stdin)")
+	outputFormat := flag.String("output", string(OUTPUT_FORMATS.Text), "Output format of `-p`, either `text` or `json`")
+	maxTurns := flag.Int("max-turns", 20, "Max amount of responses requested to the LLM with `-p`")
+	allowTools := flag.Bool("allow-tools", false, "Call tools that need approval without asking with `-p`, denied tools are still denied")
+	flag.Parse()
+
 	logfile, err := os.OpenFile(LOG_FILE, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Fatal("Failed to open log file:", err)
@@ -79,11 +124,6 @@ func main() {
 		LOG.Println("Failed to read .env file! Make sure env variables are set!")
 	}
 
-	apiKey, exists := os.LookupEnv("API_KEY")
-	if !exists {
-		LOG.Panic("Env variable `API_KEY` doesn't exists!")
-	}
-
 	// ghPAT, exists := os.LookupEnv("GITHUB_")
 	// if !exists {
 	// 	LOG.Panic("Env variable `API_KEY` doesn't exists!")
@@ -100,21 +140,78 @@ func main() {
 		LOG.Panic("Incorrect format in config:", err)
 	}
 
+	config.Model, err = LoadModelConfig(config.Model)
+	if err != nil {
+		LOG.Panic("Incorrect model config:", err)
+	}
+
+	toolPolicies, err := agent.NewToolPolicies(config.DefaultToolPolicy, config.ToolPolicies)
+	if err != nil {
+		LOG.Panic("Incorrect tool policies:", err)
+	}
+
+	for _, serverConfig := range config.Servers {
+		if err := agent.ValidateServerConfig(serverConfig); err != nil {
+			LOG.Panic("Incorrect server config:", err)
+		}
+	}
+
+	provider, err := config.Model.NewProvider()
+	if err != nil {
+		LOG.Panic("Failed to create the LLM provider:", err)
+	}
+
 	wg := sync.WaitGroup{}
 	defer func() {
-		if r := recover(); r != nil {
+		r := recover()
+		if r != nil {
 			fmt.Printf("Recovered in main:\n%s", r)
 		}
 
 		LOG.Printf("Waiting for goroutines to finish...")
 		wg.Wait()
 		LOG.Printf("Goodbye!")
+		if r != nil {
+			os.Exit(EXIT_FAILURE)
+		}
 	}()
 
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
 
-	p := tea.NewProgram(initialModel(ctx, &wg, apiKey, config))
+	m := initialModel(ctx, &wg, provider, toolPolicies, config, *prompt != "")
+	if *resumeId != "" {
+		if err := m.resumeSession(*resumeId); err != nil {
+			LOG.Panic("Failed to resume session:", err)
+		}
+	}
+
+	if *prompt != "" {
+		userPrompt, err := HeadlessPrompt(*prompt, os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(EXIT_USAGE)
+		}
+
+		// There's no point on retrying without a TUI, since nobody waits for the servers.
+		m.startServers(1)
+		m.waitForServers(ctx)
+
+		// Nobody can approve tool calls without a TUI.
+		m.session.Approve = nil
+		if *allowTools {
+			m.session.Approve = func(context.Context, llm.Block, agent.MCPTool) agent.ToolDecision {
+				return agent.TOOL_DECISIONS.AllowOnce
+			}
+		}
+		exitCode := runHeadless(ctx, m, userPrompt, OutputFormat(*outputFormat), *maxTurns, os.Stdout)
+		cancelCtx()
+		wg.Wait()
+		os.Exit(exitCode)
+	}
+
+	m.startServers(SERVER_CONNECTION_ATTEMPTS)
+	p := tea.NewProgram(m)
 	if _, err := p.Run(); err != nil {
 		LOG.Fatal(err)
 	}
@@ -122,32 +219,66 @@ func main() {
 }
 
 type model struct {
-	maxTokens        uint
-	wg               *sync.WaitGroup
-	programCtx       context.Context
-	secondaryDisplay bool
-	aiThinking       bool
-	viewport         viewport.Model
-	messages         []ant.MessageParam
-	textarea         textarea.Model
-	senderStyle      lipgloss.Style
+	maxTokens   uint
+	modelConfig ModelConfig
+	programCtx  context.Context
+	waitGroup   *sync.WaitGroup
+	display     Display
+	aiThinking  bool
+	viewport    viewport.Model
+	messages    []llm.Message
+	textarea    textarea.Model
+	senderStyle lipgloss.Style
+	errorStyle  lipgloss.Style
 
 	// AI AGENTS PROPERTIES
-	claudeClient     ant.Client
-	mcpClients       []*client.Client
-	tools            []ant.ToolUnionParam
-	clientByToolName map[string]*client.Client
-	err              error
+	session *agent.Session
+	err     error
+
+	// State of every configured server, in the order of the config.
+	servers              []ServerStatus
+	connectServer        agent.ServerConnector
+	serverUpdates        chan ServerStatusChanged
+	serverAuthorizations chan ServerAuthorization
+
+	// The LLM response currently being streamed.
+	streamMessage llm.Message
+	usage         UsageTracker
+
+	resources         []MCPResource
+	resourceTemplates []MCPResourceTemplate
+	resourceCursor    int
+	// Resources that will be sent along with the next user message.
+	attachments []ResourceAttachment
+
+	prompts    []MCPPrompt
+	promptForm *PromptForm
+
+	samplingRequests    chan SamplingRequest
+	serverNotifications chan ServerNotification
+	// Sampling requests waiting for the user's approval, the first one is displayed.
+	samplingQueue []SamplingRequest
+
+	toolApprovals chan ToolApprovalRequest
+	// Tool calls waiting for the user's approval, the first one is displayed.
+	approvalQueue []ToolApprovalRequest
+
+	sessionStore SessionStore
+	sessionId    string
+	// Amount of messages already written to the session store.
+	savedMessages int
 }
 
 func initialModel(
 	ctx context.Context,
 	wg *sync.WaitGroup,
-	apiKey string,
+	provider llm.Provider,
+	toolPolicies *agent.ToolPolicies,
 	config Config,
+	headless bool,
 ) model {
 	ta := textarea.New()
-	ta.Placeholder = "Send a message..."
+	ta.Placeholder = TEXTAREA_PLACEHOLDER
 	ta.Focus()
 
 	ta.Prompt = "| "
@@ -161,112 +292,84 @@ func initialModel(
 	ta.KeyMap.InsertNewline.SetEnabled(false)
 
 	vp := viewport.New(30, 5)
-	vp.SetContent("Welcome! Chat to claude...\nPress F1 to view help!")
-
-	antClient := ant.NewClient(
-		option.WithAPIKey(apiKey),
-	)
-
-	tools := make([]ant.ToolUnionParam, 0, len(config.Servers))
-	clientByToolName := make(map[string]*client.Client)
-	mcpClients := make([]*client.Client, 0, len(config.Servers))
-
-	for _, clientConfig := range config.Servers {
-		var err error
-		var trans transport.Interface
-		if clientConfig.Type == MCP_SERVERS_TYPE.Http {
-			trans, err = transport.NewStreamableHTTP(
-				clientConfig.URL,
-			)
-			if err != nil {
-				LOG.Printf("Failed to connect to `%s`: %s", clientConfig.URL, err)
-			}
-			LOG.Println("Connecting to (http) client:", clientConfig.Name, clientConfig.URL)
-		} else {
-			trans = transport.NewStdio(clientConfig.Command, os.Environ(), clientConfig.Args...)
-			LOG.Println("Connecting to (stdio) client:", clientConfig.Name, clientConfig.Command)
-		}
-
-		mcpClient := client.NewClient(trans)
-		err = mcpClient.Start(ctx)
-		if err != nil {
-			LOG.Printf("Failed to start client `%#v`: %s", clientConfig, err)
-			continue
-		}
-
-		mcpClient.OnNotification(func(notification mcp.JSONRPCNotification) {
-			LOG.Printf("Client `%#v` notification: %s", clientConfig, notification.Method)
-		})
-
-		LOG.Printf("Initializing client!")
-		capabilities, err := mcpClient.Initialize(ctx, mcp.InitializeRequest{
-			Params: mcp.InitializeParams{
-				ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
-				ClientInfo: mcp.Implementation{
-					Name:    "CLIude",
-					Version: "1.0.0",
-				},
-				Capabilities: mcp.ClientCapabilities{},
+	vp.SetContent(WELCOME_CONTENT)
+
+	m := model{
+		maxTokens:   config.MaxTokens,
+		modelConfig: config.Model,
+		programCtx:  ctx,
+		waitGroup:   wg,
+		textarea:    ta,
+		viewport:    vp,
+		senderStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("5")),
+		errorStyle:  lipgloss.NewStyle().Foreground(lipgloss.Color("#ff0000")),
+		err:         nil,
+
+		samplingRequests:    make(chan SamplingRequest),
+		serverNotifications: make(chan ServerNotification, SERVER_NOTIFICATIONS_BUFFER),
+		toolApprovals:       make(chan ToolApprovalRequest),
+
+		servers:              make([]ServerStatus, 0, len(config.Servers)),
+		serverUpdates:        make(chan ServerStatusChanged, SERVER_NOTIFICATIONS_BUFFER),
+		serverAuthorizations: make(chan ServerAuthorization),
+
+		sessionStore: SessionStore{Dir: config.SessionsDir},
+		sessionId:    NewSessionId(),
+		usage:        UsageTracker{Pricing: LoadPricing(config.Pricing)},
+	}
+	if m.sessionStore.Dir == "" {
+		m.sessionStore.Dir = DEFAULT_SESSIONS_DIR
+	}
+	tools := agent.NewToolRegistry()
+	tools.Logger = LOG
+	m.session = agent.NewSession(provider, tools, m.requestDefaults())
+	m.session.Logger = LOG
+	m.session.WaitGroup = wg
+	m.session.Compaction = config.Compaction
+	m.session.Policies = toolPolicies
+	m.session.Approve = NewToolApprover(m.toolApprovals)
+
+	notifications := m.serverNotifications
+	samplingRequests := m.samplingRequests
+	session := m.session
+	defaultModel := func() string {
+		return session.Defaults().Model
+	}
+	// Printing the authorization URL would break the TUI.
+	showAuthorizationURL := agent.PrintAuthorizationURL
+	if !headless {
+		showAuthorizationURL = NewAuthorizationNotifier(ctx, m.serverAuthorizations)
+	}
+	m.connectServer = func(ctx context.Context, clientConfig agent.MCPServerConfig) (*agent.MCPServer, error) {
+		return agent.ConnectServer(ctx, clientConfig, agent.ConnectOptions{
+			OnNotification: func(mcpClient *client.Client, notification mcp.JSONRPCNotification) {
+				LOG.Printf("Client `%#v` notification: %s", clientConfig, notification.Method)
+				NotifyServerChange(notifications, ServerNotification{
+					Config: clientConfig,
+					Client: mcpClient,
+					Method: notification.Method,
+				})
 			},
+			ShowAuthorizationURL: showAuthorizationURL,
+			ClientOptions: []client.ClientOption{client.WithSamplingHandler(&SamplingHandler{
+				ServerName:     clientConfig.Name,
+				Provider:       provider,
+				DefaultModel:   defaultModel,
+				UseModelHints:  config.Model.Provider == PROVIDERS_TYPE.Anthropic,
+				MaxTokens:      config.MaxTokens,
+				MaxTemperature: MaxTemperature(config.Model.Provider),
+				Requests:       samplingRequests,
+			})},
+			Logger: LOG,
 		})
-		if err != nil {
-			LOG.Panicf("Failed to connect to client: %s", err)
-		}
-		LOG.Printf("Server capabilities:\n%#v", capabilities)
-		mcpClients = append(mcpClients, mcpClient)
-
-		if capabilities.Capabilities.Tools != nil {
-			var defaultCursor mcp.Cursor
-			var cursor mcp.Cursor
-			for {
-				svTools, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{
-					PaginatedRequest: mcp.PaginatedRequest{
-						Params: mcp.PaginatedParams{
-							Cursor: cursor,
-						},
-					},
-				})
-				if err != nil {
-					LOG.Panicf("Failed to obtain tools for client: %s", err)
-				}
-
-				for _, tool := range svTools.Tools {
-					LOG.Printf("Adding tool: %s - %s\nThe tool has the following schema:\n%#v", tool.Name, tool.Description, tool.InputSchema)
-
-					clientByToolName[tool.Name] = mcpClient
-					tools = append(tools, ant.ToolUnionParam{
-						OfTool: &ant.ToolParam{
-							Name:        tool.Name,
-							Description: param.NewOpt(tool.Description),
-							InputSchema: ant.ToolInputSchemaParam{
-								Required:   tool.InputSchema.Required,
-								Properties: tool.InputSchema.Properties,
-							},
-						},
-					})
-				}
-
-				if svTools.NextCursor == defaultCursor {
-					break // No more pages
-				}
-				cursor = svTools.NextCursor
-			}
-		}
 	}
-
-	return model{
-		maxTokens:        config.MaxTokens,
-		wg:               wg,
-		programCtx:       ctx,
-		textarea:         ta,
-		viewport:         vp,
-		senderStyle:      lipgloss.NewStyle().Foreground(lipgloss.Color("5")),
-		claudeClient:     antClient,
-		mcpClients:       mcpClients,
-		clientByToolName: clientByToolName,
-		err:              nil,
-		tools:            tools,
+	for _, clientConfig := range config.Servers {
+		m.servers = append(m.servers, ServerStatus{
+			ServerStatus: agent.ServerStatus{Config: clientConfig, State: agent.SERVER_STATES.Connecting},
+		})
 	}
+
+	return m
 }
 
 func (m model) StringMessages() []string {
@@ -274,33 +377,46 @@ func (m model) StringMessages() []string {
 	for _, msg := range m.messages {
 		strMsg := strings.Builder{}
 		author := "You:"
-		if msg.Role == "assistant" {
-			author = "Claude:"
+		if msg.Role == llm.RoleAssistant {
+			author = m.session.Provider.Name() + ":"
 		}
 
 		strMsg.WriteString(m.senderStyle.Render(author))
 		strMsg.WriteRune(' ')
 		for _, ct := range msg.Content {
-			if param.IsOmitted(ct) {
-				continue
-			}
-
-			if ct.OfText != nil {
-				strMsg.WriteString(ct.OfText.Text)
-			} else if ct.OfToolUse != nil {
-				toolName := ct.OfToolUse.Name
+			switch ct.Type {
+			case llm.BlockText:
+				strMsg.WriteString(ct.Text)
+			case llm.BlockToolUse:
 				strMsg.WriteString(" (Trying to use tool `")
-				strMsg.WriteString(toolName)
-				strMsg.WriteString("`)")
-			} else if ct.OfToolResult != nil {
-				if ct.OfToolResult.IsError.Value {
-					strMsg.WriteString(" (Failed to use tool!)")
+				strMsg.WriteString(ct.ToolName)
+				strMsg.WriteString("`")
+				if len(ct.Input) > 0 {
+					strMsg.WriteString(" with ")
+					strMsg.Write(ct.Input)
+				}
+				strMsg.WriteString(")")
+			case llm.BlockToolResult:
+				if ct.IsError {
+					reason := "Failed to use tool!"
+					for _, resultCt := range ct.Content {
+						if resultCt.Type == llm.BlockText {
+							reason = "Failed to use tool: " + resultCt.Text
+							break
+						}
+					}
+					strMsg.WriteString(" ")
+					strMsg.WriteString(m.errorStyle.Render("(" + reason + ")"))
 				} else {
 					strMsg.WriteString(" (Used tool successfully!)")
 				}
-			} else if ct.OfThinking != nil {
+			case llm.BlockImage:
+				strMsg.WriteString(" (Attached an image)")
+			case llm.BlockDocument:
+				strMsg.WriteString(" (Attached a document)")
+			case llm.BlockThinking:
 				strMsg.WriteString(" (AI is thinking...)")
-			} else {
+			default:
 				strMsg.WriteString(" (Can't display block type on terminal!)")
 			}
 		}
@@ -311,7 +427,14 @@ func (m model) StringMessages() []string {
 }
 
 func (m model) Init() tea.Cmd {
-	return textarea.Blink
+	return tea.Batch(
+		textarea.Blink,
+		waitForSamplingRequest(m.samplingRequests),
+		waitForServerNotification(m.serverNotifications),
+		waitForToolApproval(m.toolApprovals),
+		waitForServerStatus(m.serverUpdates),
+		waitForServerAuthorization(m.serverAuthorizations),
+	)
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -322,194 +445,420 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	m.textarea, taCmd = m.textarea.Update(msg)
 	m.viewport, vpCmd = m.viewport.Update(msg)
-	viewportWidthStyle := lipgloss.NewStyle().Width(m.viewport.Width)
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.viewport.Width = msg.Width
 		m.textarea.SetWidth(msg.Width)
-		m.viewport.Height = msg.Height - m.textarea.Height() - lipgloss.Height(GAP)
+		m.viewport.Height = msg.Height - m.textarea.Height() - lipgloss.Height(GAP) - STATUS_BAR_HEIGHT
 
-		if len(m.messages) > 0 {
+		if len(m.messages) > 0 || m.display != DISPLAYS.Chat {
 			// Wrap content before setting it.
-			m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.StringMessages(), "\n")))
+			m.showDisplay(m.display)
 		}
 		m.viewport.GotoBottom()
 	case tea.KeyMsg:
+		if len(m.samplingQueue) > 0 && (msg.String() == "y" || msg.String() == "n") {
+			request := m.samplingQueue[0]
+			request.Decide(msg.String() == "y")
+			m.samplingQueue = m.samplingQueue[1:]
+			m.showPendingRequest()
+			return m, tea.Batch(taCmd, vpCmd)
+		}
+		if decision, found := TOOL_DECISION_KEYS[msg.String()]; found && len(m.samplingQueue) == 0 && len(m.approvalQueue) > 0 {
+			request := m.approvalQueue[0]
+			request.Decide(decision)
+			m.approvalQueue = m.approvalQueue[1:]
+			m.showPendingRequest()
+			return m, tea.Batch(taCmd, vpCmd)
+		}
+
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		case tea.KeyEsc:
+			if m.promptForm != nil {
+				m.promptForm = nil
+				m.textarea.Placeholder = TEXTAREA_PLACEHOLDER
+				m.textarea.Reset()
+				return m, tea.Batch(taCmd, vpCmd)
+			}
+			if m.session.Running() {
+				m.cancelAgent()
+				return m, tea.Batch(taCmd, vpCmd)
+			}
+			return m, tea.Quit
+		case tea.KeyCtrlC:
 			return m, tea.Quit
 		case tea.KeyF1:
-			m.secondaryDisplay = !m.secondaryDisplay
-			if m.secondaryDisplay {
-				m.viewport.SetContent(viewportWidthStyle.Render(HELP_CONTENT))
-			} else {
-				m.viewport.SetContent(viewportWidthStyle.Render(strings.Join(m.StringMessages(), "\n")))
-			}
+			m.toggleDisplay(DISPLAYS.Help)
 		case tea.KeyF2:
-			m.secondaryDisplay = !m.secondaryDisplay
-			if m.secondaryDisplay {
-				logsContent := []byte{}
-				if file, err := os.Open(LOG_FILE); err == nil {
-					logsContent, _ = io.ReadAll(file)
+			m.toggleDisplay(DISPLAYS.Logs)
+		case tea.KeyF3:
+			m.toggleDisplay(DISPLAYS.Resources)
+		case tea.KeyUp, tea.KeyDown:
+			if m.display == DISPLAYS.Resources {
+				if msg.Type == tea.KeyUp {
+					m.resourceCursor = max(m.resourceCursor-1, 0)
+				} else {
+					m.resourceCursor = min(m.resourceCursor+1, max(len(m.resources)+len(m.resourceTemplates)-1, 0))
 				}
-				m.viewport.SetContent(viewportWidthStyle.Render(string(logsContent)))
-			} else {
-				m.viewport.SetContent(viewportWidthStyle.Render(strings.Join(m.StringMessages(), "\n")))
+				m.showDisplay(DISPLAYS.Resources)
+			}
+		case tea.KeyDelete:
+			m.attachments = nil
+			if m.display == DISPLAYS.Resources {
+				m.showDisplay(DISPLAYS.Resources)
 			}
 
 		case tea.KeyEnter:
+			if m.display == DISPLAYS.Resources {
+				if m.resourceCursor < len(m.resources) {
+					resource := m.resources[m.resourceCursor]
+					return m, tea.Batch(taCmd, vpCmd, readResource(m.programCtx, resource.Client, resource.Resource.URI))
+				}
+
+				templateIdx := m.resourceCursor - len(m.resources)
+				uri := strings.TrimSpace(m.textarea.Value())
+				if templateIdx >= len(m.resourceTemplates) || uri == "" {
+					return m, tea.Batch(taCmd, vpCmd)
+				}
+				m.textarea.Reset()
+				template := m.resourceTemplates[templateIdx]
+				return m, tea.Batch(taCmd, vpCmd, readResource(m.programCtx, template.Client, uri))
+			}
+
 			userMsg := m.textarea.Value()
-			authorMsg := ant.NewUserMessage(
-				ant.NewTextBlock(userMsg),
-			)
-
-			m.messages = append(m.messages, authorMsg)
-			claudeCmd := claudeCall(m.programCtx, &m)
-			m.messages = append(m.messages, ant.MessageParam{
-				Role: "assistant",
-				Content: []ant.ContentBlockParamUnion{
-					ant.NewThinkingBlock("", ""),
-				},
-			})
-
-			m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.StringMessages(), "\n")))
+			if m.promptForm != nil {
+				var promptCmd tea.Cmd
+				m.textarea.Reset()
+				m, promptCmd = m.submitPromptArgument(userMsg)
+				return m, tea.Batch(taCmd, vpCmd, promptCmd)
+			}
+			if strings.HasPrefix(userMsg, "/") {
+				var commandCmd tea.Cmd
+				m.textarea.Reset()
+				m, commandCmd = m.runCommand(userMsg)
+				return m, tea.Batch(taCmd, vpCmd, commandCmd)
+			}
+			if strings.TrimSpace(userMsg) == "" && len(m.attachments) == 0 {
+				return m, tea.Batch(taCmd, vpCmd)
+			}
+
+			authorMsg := llm.NewUserMessage()
+			if strings.TrimSpace(userMsg) != "" {
+				authorMsg.Content = append(authorMsg.Content, llm.NewTextBlock(userMsg))
+			}
+			for _, attachment := range m.attachments {
+				authorMsg.Content = append(authorMsg.Content, attachment.Blocks...)
+			}
+			m.attachments = nil
+
+			agentCmd := m.runAgent(authorMsg)
+			m.display = DISPLAYS.Chat
+			m.refreshChat()
 			m.textarea.Reset()
-			m.viewport.GotoBottom()
-			return m, tea.Batch(taCmd, vpCmd, claudeCmd)
+			return m, tea.Batch(taCmd, vpCmd, agentCmd)
+		}
+
+	case PromptResponse:
+		LOG.Printf("Prompt `%s` returned %d messages", msg.Command, len(msg.Result.Messages))
+		promptMessages := PromptMessages(msg.Result)
+		m.display = DISPLAYS.Chat
+
+		if len(promptMessages) > 0 && promptMessages[len(promptMessages)-1].Role == llm.RoleUser {
+			agentCmd := m.runAgent(promptMessages...)
+			m.refreshChat()
+			return m, tea.Batch(taCmd, vpCmd, agentCmd)
+		}
+		if err := m.session.Append(promptMessages...); err != nil {
+			m.err = err
+		}
+		m.saveSession()
+		m.syncMessages()
+		m.refreshChat()
+
+	case SamplingRequest:
+		m.samplingQueue = append(m.samplingQueue, msg)
+		m.textarea.Blur()
+		m.showDisplay(DISPLAYS.Sampling)
+		return m, tea.Batch(taCmd, vpCmd, waitForSamplingRequest(m.samplingRequests))
+
+	case ToolApprovalRequest:
+		if msg.Cancelled() {
+			return m, tea.Batch(taCmd, vpCmd, waitForToolApproval(m.toolApprovals))
+		}
+		m.approvalQueue = append(m.approvalQueue, msg)
+		m.textarea.Blur()
+		if len(m.samplingQueue) == 0 {
+			m.showDisplay(DISPLAYS.ToolApproval)
+		}
+		return m, tea.Batch(taCmd, vpCmd, waitForToolApproval(m.toolApprovals))
+
+	case ServerStatusChanged:
+		m.setServerStatus(msg)
+		if m.display == DISPLAYS.Servers || m.display == DISPLAYS.Resources {
+			m.showDisplay(m.display)
+		}
+		return m, tea.Batch(taCmd, vpCmd, waitForServerStatus(m.serverUpdates))
+
+	case ServerAuthorization:
+		m.setServerAuthorization(msg)
+		// The user must see the URL, unless they're answering a request.
+		if len(m.samplingQueue) == 0 && len(m.approvalQueue) == 0 {
+			m.showDisplay(DISPLAYS.Servers)
+		}
+		return m, tea.Batch(taCmd, vpCmd, waitForServerAuthorization(m.serverAuthorizations))
+
+	case ServerNotification:
+		refreshCmd := refreshServerLists(m.programCtx, msg)
+		return m, tea.Batch(taCmd, vpCmd, refreshCmd, waitForServerNotification(m.serverNotifications))
+
+	// The lists of a server that restarted since the refresh began are dropped,
+	// its supervisor already listed them with the new client.
+	case ServerToolsChanged:
+		if m.isCurrentClient(msg.ServerName, msg.Client) {
+			m.session.Tools.SetServerTools(msg.ServerName, msg.Tools)
+		}
+
+	case ServerResourcesChanged:
+		if m.isCurrentClient(msg.ServerName, msg.Client) {
+			m.setServerResources(msg.ServerName, msg.Resources, msg.Templates)
+		}
+		if m.display == DISPLAYS.Resources {
+			m.showDisplay(DISPLAYS.Resources)
 		}
 
-	// We handle errors just like any other message
+	case ServerPromptsChanged:
+		if m.isCurrentClient(msg.ServerName, msg.Client) {
+			m.setServerPrompts(msg.ServerName, msg.Prompts)
+		}
+
+	case ResourceAttachment:
+		m.attachments = append(m.attachments, msg)
+		if m.display == DISPLAYS.Resources {
+			m.showDisplay(DISPLAYS.Resources)
+		}
+
+	// We handle errors just like any other message.
+	// They come from commands like reading a resource, the agent reports its own as events.
 	case error:
-		m.aiThinking = false
 		m.err = msg
 		return m, nil
-	case ClaudeResponse:
-		m.aiThinking = false
-		m.messages[len(m.messages)-1] = msg.ToParam() // Replaces last message with Claude real response
-		m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.StringMessages(), "\n")))
-		m.viewport.GotoBottom()
-
-		if msg.StopReason == ant.StopReasonToolUse {
-			toolBlock := msg.Content[len(msg.Content)-1].AsToolUse()
-			toolName := toolBlock.Name
-			client, found := m.clientByToolName[toolName]
-			if !found {
-				LOG.Panic("Claude tried to use", toolName, ". But this tool doesn't exist!")
+	case AgentEvent:
+		event := msg.Event
+		switch event.Type {
+		case agent.EVENT_TYPES.Delta:
+			m.streamMessage.Accumulate(event.Delta)
+		case agent.EVENT_TYPES.Message:
+			m.streamMessage = llm.Message{}
+			m.saveSession()
+		case agent.EVENT_TYPES.Usage:
+			model := event.Model
+			if model == "" {
+				model = m.modelConfig.Id
 			}
+			m.usage.Add(model, event.Usage)
+		case agent.EVENT_TYPES.Compaction:
+			m.saveCompactedSession()
+		case agent.EVENT_TYPES.Error:
+			m.err = event.Err
+		}
+		m.syncMessages()
+		m.refreshChat()
+		return m, tea.Batch(taCmd, vpCmd, waitForAgentEvent(msg.events))
+
+	case AgentDone:
+		m.aiThinking = false
+		m.streamMessage = llm.Message{}
+		m.saveSession()
+		m.syncMessages()
+		m.refreshChat()
 
-			toolCmd := toolCall(m.programCtx, client, toolBlock)
-			return m, tea.Batch(taCmd, vpCmd, toolCmd)
+	case CompactionDone:
+		m.aiThinking = false
+		if msg.Err != nil {
+			m.err = msg.Err
+		} else {
+			m.saveCompactedSession()
 		}
+		m.syncMessages()
+		m.refreshChat()
+	}
 
-	case ToolResponse:
-		blocks := make([]ant.ContentBlockParamUnion, 0, len(msg.MCPResponse.Content))
-		for _, ct := range msg.MCPResponse.Content {
-			switch ct := ct.(type) {
-			case mcp.TextContent:
-				resultBlock := ant.NewToolResultBlock(msg.ToolId, ct.Text, strings.Contains(ct.Text, "Error"))
-				blocks = append(blocks, resultBlock)
-			default:
-				LOG.Printf("Unsupported block type for tool response! %#v", ct)
+	return m, tea.Batch(taCmd, vpCmd)
+}
+
+// Shows `display` on the viewport or goes back to the chat if it's already shown.
+func (m *model) toggleDisplay(display Display) {
+	if m.display == display {
+		m.showDisplay(DISPLAYS.Chat)
+	} else {
+		m.showDisplay(display)
+	}
+}
+
+func (m *model) showDisplay(display Display) {
+	m.display = display
+	widthStyle := lipgloss.NewStyle().Width(m.viewport.Width)
+
+	switch display {
+	case DISPLAYS.Help:
+		help := HELP_CONTENT + "\nCurrent model: " + m.modelConfig.Id + "\n"
+		if len(m.prompts) > 0 {
+			help += "\nAvailable prompts:\n"
+			for _, prompt := range m.prompts {
+				help += prompt.Command + " - " + prompt.Prompt.Description + "\n"
 			}
 		}
-		toolResponse := ant.NewUserMessage()
-		toolResponse.Content = blocks
-
-		m.messages = append(m.messages, toolResponse)
-		claudeCmd := claudeCall(m.programCtx, &m)
-		m.messages = append(m.messages, ant.MessageParam{
-			Role: "assistant",
-			Content: []ant.ContentBlockParamUnion{
-				ant.NewThinkingBlock("", ""),
-			},
-		})
+		m.viewport.SetContent(widthStyle.Render(help))
+	case DISPLAYS.Logs:
+		logsContent := []byte{}
+		if file, err := os.Open(LOG_FILE); err == nil {
+			logsContent, _ = io.ReadAll(file)
+			file.Close()
+		}
+		m.viewport.SetContent(widthStyle.Render(string(logsContent)))
+	case DISPLAYS.Resources:
+		m.viewport.SetContent(widthStyle.Render(m.ResourcesView()))
+	case DISPLAYS.Sampling:
+		m.viewport.SetContent(widthStyle.Render(m.SamplingView()))
+	case DISPLAYS.Sessions:
+		m.viewport.SetContent(widthStyle.Render(m.SessionsView()))
+	case DISPLAYS.ToolApproval:
+		m.viewport.SetContent(widthStyle.Render(m.ToolApprovalView()))
+	case DISPLAYS.Servers:
+		m.viewport.SetContent(widthStyle.Render(m.ServersView()))
+	default:
+		m.refreshChat()
+	}
+}
 
-		m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.StringMessages(), "\n")))
-		m.viewport.GotoBottom()
-		return m, tea.Batch(taCmd, vpCmd, claudeCmd)
+// Shows the next request waiting for the user's approval,
+// going back to the chat once there are none.
+func (m *model) showPendingRequest() {
+	if len(m.samplingQueue) > 0 {
+		m.showDisplay(DISPLAYS.Sampling)
+	} else if len(m.approvalQueue) > 0 {
+		m.showDisplay(DISPLAYS.ToolApproval)
+	} else {
+		m.textarea.Focus()
+		m.showDisplay(DISPLAYS.Chat)
 	}
+}
 
-	return m, tea.Batch(taCmd, vpCmd)
+// Renders the conversation again, only if it's currently being displayed.
+func (m *model) refreshChat() {
+	if m.display != DISPLAYS.Chat {
+		return
+	}
+
+	if len(m.messages) == 0 {
+		m.viewport.SetContent(WELCOME_CONTENT)
+		return
+	}
+
+	m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.StringMessages(), "\n")))
+	m.viewport.GotoBottom()
+}
+
+// Base of every request to the LLM, built from the model config.
+func (m model) requestDefaults() llm.Request {
+	request := llm.Request{MaxTokens: int64(m.maxTokens)}
+	m.modelConfig.Apply(&request)
+	return request
 }
 
-func toolCall(ctx context.Context, client *client.Client, toolInfo ant.ToolUseBlock) tea.Cmd {
-	ctx, cancelCtx := context.WithTimeout(ctx, 20*time.Minute)
+// Sends messages to the agent session and starts listening to its events.
+func (m *model) runAgent(messages ...llm.Message) tea.Cmd {
+	events, err := m.session.Run(m.programCtx, messages...)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+
+	m.err = nil
+	m.aiThinking = true
+	m.streamMessage = llm.Message{}
+	m.saveSession()
+	m.syncMessages()
+	return waitForAgentEvent(events)
+}
+
+// Compacts the conversation of the session in the background.
+func compactSession(ctx context.Context, session *agent.Session) tea.Cmd {
 	return func() tea.Msg {
-		defer cancelCtx()
+		result, err := session.Compact(ctx)
+		return CompactionDone{Result: result, Err: err}
+	}
+}
 
-		bytes, err := toolInfo.Input.MarshalJSON()
-		if err != nil {
-			LOG.Panicf("Failed to format: %#v: %s", toolInfo.Input, err)
-		}
+// Cancels the run of the agent session, denying every tool call waiting for approval.
+// The run finishes once the session closes its events.
+func (m *model) cancelAgent() {
+	if !m.session.Cancel() {
+		return
+	}
 
-		params := map[string]any{}
-		err = json.Unmarshal(bytes, &params)
-		if err != nil {
-			LOG.Panicf("Failed to unmarshall into a map: %s\n%s", err, string(bytes))
-		}
+	for _, request := range m.approvalQueue {
+		request.Decide(agent.TOOL_DECISIONS.Deny)
+	}
+	m.approvalQueue = nil
+	m.showPendingRequest()
+}
 
-		LOG.Printf("Calling tool `%s` with: %#v", toolInfo.Name, params)
-		resp, err := client.CallTool(ctx, mcp.CallToolRequest{
-			Params: mcp.CallToolParams{
-				Name:      toolInfo.Name,
-				Arguments: params,
-			},
-		})
-		if err != nil {
-			LOG.Println("ERROR: Failed to call tool:", err)
-			return err
-		}
-		LOG.Printf("Tool `%s` responded with: %#v", toolInfo.Name, resp)
+// Copies the conversation of the session, along with the response being streamed.
+func (m *model) syncMessages() {
+	m.messages = m.session.Messages()
 
-		return ToolResponse{
-			IsError:     false,
-			MCPResponse: resp,
-			ToolId:      toolInfo.ID,
-		}
+	waitingResponse := len(m.messages) == 0 || m.messages[len(m.messages)-1].Role == llm.RoleUser
+	if !m.aiThinking || !waitingResponse {
+		return
+	}
+	if len(m.streamMessage.Content) > 0 {
+		m.messages = append(m.messages, m.streamMessage)
+	} else {
+		m.messages = append(m.messages, llm.NewAssistantMessage(llm.NewThinkingBlock("", "")))
 	}
 }
 
-func claudeCall(ctx context.Context, m *model) tea.Cmd {
-	ctx, cancelCtx := context.WithTimeout(ctx, 10*time.Minute)
-	messages := m.messages
+// Waits for the next event of the agent session.
+func waitForAgentEvent(events <-chan agent.Event) tea.Cmd {
 	return func() tea.Msg {
-		defer cancelCtx()
-		m.aiThinking = true
-
-		LOG.Println("Calling claude for response...")
-		message, err := m.claudeClient.Messages.New(ctx, ant.MessageNewParams{
-			MaxTokens: int64(m.maxTokens),
-			Messages:  messages,
-			Model:     ant.ModelClaudeSonnet4_20250514,
-			Tools:     m.tools,
-		},
-			option.WithDebugLog(LOG),
-		)
-
-		if err != nil {
-			LOG.Println("Failed to get response from claude:", err)
-			return err
-		} else {
-			LOG.Println("Claude responded correctly!")
-			return message
+		event, ok := <-events
+		if !ok {
+			return AgentDone{}
 		}
+		return AgentEvent{Event: event, events: events}
 	}
 }
 
 func (m model) View() string {
+	gap := GAP
+	gapStyle := m.senderStyle.MaxWidth(m.viewport.Width)
+	if len(m.attachments) > 0 {
+		uris := make([]string, 0, len(m.attachments))
+		for _, attachment := range m.attachments {
+			uris = append(uris, attachment.URI)
+		}
+		gap = "\n" + gapStyle.Render("Attached: "+strings.Join(uris, ", ")) + "\n"
+	}
+	if input := m.textarea.Value(); strings.HasPrefix(input, "/") && m.promptForm == nil {
+		gap = "\n" + gapStyle.Render("Commands: "+strings.Join(m.MatchingCommands(input), " ")) + "\n"
+	}
+
 	if m.err != nil {
 		return fmt.Sprintf(
-			"%s\n%s\n%s",
+			"%s\n%s\n%s\n%s",
 			m.viewport.View(),
-			lipgloss.NewStyle().Foreground(lipgloss.Color("#ff0000")).Render("ERROR: ")+m.err.Error(),
+			m.errorStyle.Render("ERROR: ")+m.err.Error(),
+			m.StatusBarView(),
 			m.textarea.View(),
 		)
 	} else {
 		return fmt.Sprintf(
-			"%s%s%s",
+			"%s%s%s\n%s",
 			m.viewport.View(),
-			GAP,
+			gap,
+			m.StatusBarView(),
 			m.textarea.View(),
 		)
 	}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	ant "github.com/anthropics/anthropic-sdk-go"
+)
+
+const DEFAULT_MODEL = ant.ModelClaudeSonnet4_20250514
+
+// Models used when only a family name like `haiku` is given.
+var MODEL_FAMILIES = map[string]ant.Model{
+	"haiku":  ant.ModelClaude3_5HaikuLatest,
+	"sonnet": ant.ModelClaudeSonnet4_0,
+	"opus":   ant.ModelClaudeOpus4_1_20250805,
+}
+
+type ProviderType string
+
+var PROVIDERS_TYPE = struct {
+	Anthropic ProviderType
+	OpenAI    ProviderType
+}{
+	Anthropic: "anthropic",
+	OpenAI:    "openai",
+}
+
+// Parts of the request that can be cached.
+var CACHE_PARTS = struct {
+	Tools    string
+	System   string
+	Messages string
+}{
+	Tools:    "tools",
+	System:   "system",
+	Messages: "messages",
+}
+
+const DEFAULT_OPENAI_BASE_URL = "https://api.openai.com/v1"
+const DEFAULT_OPENAI_API_KEY_ENV = "OPENAI_API_KEY"
+
+// Highest temperature each API accepts, the lowest is always 0.
+const ANTHROPIC_MAX_TEMPERATURE = 1.0
+const OPENAI_MAX_TEMPERATURE = 2.0
+
+// Highest temperature the API of the provider accepts.
+func MaxTemperature(provider ProviderType) float64 {
+	if provider == PROVIDERS_TYPE.OpenAI {
+		return OPENAI_MAX_TEMPERATURE
+	}
+	return ANTHROPIC_MAX_TEMPERATURE
+}
+
+// The `[Model]` section of the config.
+// Every field is optional, the API defaults are used for the missing ones.
+type ModelConfig struct {
+	// Either `anthropic` (the default) or `openai` for any OpenAI compatible API.
+	Provider ProviderType
+	// Base URL of an OpenAI compatible API, like `http://localhost:11434/v1` for Ollama.
+	BaseURL string
+	// Environment variable with the API key of an OpenAI compatible API.
+	ApiKeyEnv string
+	// Either a full model ID or, for Anthropic, a family name (`haiku`, `sonnet` or `opus`).
+	Id           string
+	SystemPrompt string
+	// Path to a file with the system prompt, it's appended to `SystemPrompt`.
+	SystemPromptFile string
+	Temperature      *float64
+	TopP             *float64
+	StopSequences    []string
+	// One of `auto`, `any`, `none` or the (namespaced) name of a tool.
+	// Forcing a tool only applies to the first request of a run.
+	ToolChoice string
+	// Parts of the request cached by Anthropic: `tools`, `system` and `messages`.
+	// Every part is cached if missing, an empty list disables caching.
+	Cache []string
+}
+
+// Validates the config and reads the system prompt file if any.
+func LoadModelConfig(config ModelConfig) (ModelConfig, error) {
+	switch config.Provider {
+	case "", PROVIDERS_TYPE.Anthropic:
+		config.Provider = PROVIDERS_TYPE.Anthropic
+		config.Id = string(ResolveModel(config.Id))
+	case PROVIDERS_TYPE.OpenAI:
+		if strings.TrimSpace(config.Id) == "" {
+			return config, fmt.Errorf("a model ID is required for the `%s` provider", config.Provider)
+		}
+		if config.BaseURL == "" {
+			config.BaseURL = DEFAULT_OPENAI_BASE_URL
+		}
+		if config.ApiKeyEnv == "" {
+			config.ApiKeyEnv = DEFAULT_OPENAI_API_KEY_ENV
+		}
+	default:
+		return config, fmt.Errorf("unknown model provider `%s`", config.Provider)
+	}
+
+	if config.SystemPromptFile != "" {
+		contents, err := os.ReadFile(config.SystemPromptFile)
+		if err != nil {
+			return config, fmt.Errorf("failed to read system prompt file: %w", err)
+		}
+
+		config.SystemPrompt = strings.TrimSpace(config.SystemPrompt + "\n\n" + string(contents))
+		config.SystemPromptFile = ""
+	}
+
+	maxTemperature := MaxTemperature(config.Provider)
+	if config.Temperature != nil && (*config.Temperature < 0 || *config.Temperature > maxTemperature) {
+		return config, fmt.Errorf("temperature must be between 0 and %g for `%s`, got %f", maxTemperature, config.Provider, *config.Temperature)
+	}
+	if config.TopP != nil && (*config.TopP < 0 || *config.TopP > 1) {
+		return config, fmt.Errorf("top_p must be between 0 and 1, got %f", *config.TopP)
+	}
+
+	if config.Cache == nil {
+		config.Cache = []string{CACHE_PARTS.Tools, CACHE_PARTS.System, CACHE_PARTS.Messages}
+	}
+	for _, part := range config.Cache {
+		if part != CACHE_PARTS.Tools && part != CACHE_PARTS.System && part != CACHE_PARTS.Messages {
+			return config, fmt.Errorf("unknown cache part `%s`, use `tools`, `system` or `messages`", part)
+		}
+	}
+
+	return config, nil
+}
+
+// Creates the provider the config points to.
+// The Anthropic API key comes from `API_KEY`, the OpenAI one from `ApiKeyEnv`.
+func (config ModelConfig) NewProvider() (llm.Provider, error) {
+	if config.Provider == PROVIDERS_TYPE.OpenAI {
+		// Local servers don't need an API key.
+		apiKey := os.Getenv(config.ApiKeyEnv)
+		return llm.NewOpenAIProvider(config.BaseURL, apiKey, LOG), nil
+	}
+
+	apiKey, found := os.LookupEnv("API_KEY")
+	if !found {
+		return nil, fmt.Errorf("no `API_KEY` env variable found")
+	}
+	return llm.NewAnthropicProvider(apiKey, LOG), nil
+}
+
+// Obtains the model for an ID or family name, an empty one means the default model.
+func ResolveModel(id string) ant.Model {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return DEFAULT_MODEL
+	}
+	if model, found := MODEL_FAMILIES[strings.ToLower(id)]; found {
+		return model
+	}
+	return ant.Model(id)
+}
+
+// Resolves a model ID given by the user, family names are only valid for Anthropic.
+func (config ModelConfig) ResolveModel(id string) string {
+	if config.Provider == PROVIDERS_TYPE.OpenAI {
+		return strings.TrimSpace(id)
+	}
+	return string(ResolveModel(id))
+}
+
+// Sets every configured parameter on a request to the LLM.
+func (config ModelConfig) Apply(request *llm.Request) {
+	request.Model = config.ResolveModel(config.Id)
+	request.System = config.SystemPrompt
+	request.Temperature = config.Temperature
+	request.TopP = config.TopP
+	if len(config.StopSequences) > 0 {
+		request.StopSequences = config.StopSequences
+	}
+	request.ToolChoice = config.ToolChoice
+	request.Cache = llm.CacheOptions{
+		Tools:    slices.Contains(config.Cache, CACHE_PARTS.Tools),
+		System:   slices.Contains(config.Cache, CACHE_PARTS.System),
+		Messages: slices.Contains(config.Cache, CACHE_PARTS.Messages),
+	}
+}
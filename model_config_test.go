@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	ant "github.com/anthropics/anthropic-sdk-go"
+)
+
+func Test_ResolveModel(t *testing.T) {
+	if model := ResolveModel(""); model != DEFAULT_MODEL {
+		t.Errorf("Expected the default model, got `%s`", model)
+	}
+	if model := ResolveModel("Haiku"); model != MODEL_FAMILIES["haiku"] {
+		t.Errorf("Expected a haiku model, got `%s`", model)
+	}
+	if model := ResolveModel("claude-opus-4-0"); model != ant.ModelClaudeOpus4_0 {
+		t.Errorf("Expected the model ID to be kept, got `%s`", model)
+	}
+
+	config := ModelConfig{Provider: PROVIDERS_TYPE.OpenAI}
+	if model := config.ResolveModel("haiku"); model != "haiku" {
+		t.Errorf("Expected family names to be ignored for OpenAI, got `%s`", model)
+	}
+}
+
+func Test_LoadModelConfig(t *testing.T) {
+	config, err := LoadModelConfig(ModelConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Provider != PROVIDERS_TYPE.Anthropic || config.Id != string(DEFAULT_MODEL) {
+		t.Errorf("Expected the default Anthropic model, got %s `%s`", config.Provider, config.Id)
+	}
+
+	if _, err := LoadModelConfig(ModelConfig{Provider: PROVIDERS_TYPE.OpenAI}); err == nil {
+		t.Error("Expected an error for an OpenAI config without a model ID!")
+	}
+
+	config, err = LoadModelConfig(ModelConfig{Provider: PROVIDERS_TYPE.OpenAI, Id: "llama3.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.BaseURL != DEFAULT_OPENAI_BASE_URL || config.ApiKeyEnv != DEFAULT_OPENAI_API_KEY_ENV {
+		t.Errorf("Expected the OpenAI defaults, got `%s` and `%s`", config.BaseURL, config.ApiKeyEnv)
+	}
+
+	if _, err := LoadModelConfig(ModelConfig{Provider: "gemini"}); err == nil {
+		t.Error("Expected an error for an unknown provider!")
+	}
+
+	temperature := 1.5
+	if _, err := LoadModelConfig(ModelConfig{Temperature: &temperature}); err == nil {
+		t.Error("Expected an error for an Anthropic temperature above 1!")
+	}
+	if _, err := LoadModelConfig(ModelConfig{Provider: PROVIDERS_TYPE.OpenAI, Id: "gpt-4o", Temperature: &temperature}); err != nil {
+		t.Errorf("Expected OpenAI to accept a temperature up to 2: %s", err)
+	}
+}
+
+func Test_ModelConfigApply(t *testing.T) {
+	temperature := 0.2
+	config := ModelConfig{
+		Id:           "haiku",
+		SystemPrompt: "Be brief.",
+		Temperature:  &temperature,
+		ToolChoice:   "gh__search",
+	}
+
+	request := llm.Request{}
+	config.Apply(&request)
+	if request.Model != string(MODEL_FAMILIES["haiku"]) {
+		t.Errorf("Expected a haiku model, got `%s`", request.Model)
+	}
+	if request.System != "Be brief." {
+		t.Errorf("Expected the system prompt to be set, got `%s`", request.System)
+	}
+	if request.Temperature == nil || *request.Temperature != 0.2 {
+		t.Errorf("Expected a temperature of 0.2, got %v", request.Temperature)
+	}
+	if request.ToolChoice != "gh__search" {
+		t.Errorf("Expected the tool choice to be `gh__search`, got `%s`", request.ToolChoice)
+	}
+
+	// Without tools Claude rejects any tool choice.
+	params := llm.AnthropicParams(request)
+	if params.ToolChoice.OfTool != nil {
+		t.Error("Tool choice can't be set without tools!")
+	}
+	request.Tools = []llm.Tool{{Name: "gh__search"}}
+	params = llm.AnthropicParams(request)
+	if params.ToolChoice.OfTool == nil || params.ToolChoice.OfTool.Name != "gh__search" {
+		t.Errorf("Expected the tool choice to be `gh__search`, got %#v", params.ToolChoice)
+	}
+}
+
+func Test_ModelConfigCache(t *testing.T) {
+	config, err := LoadModelConfig(ModelConfig{Id: "sonnet", SystemPrompt: "Be brief."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := llm.Request{
+		Tools: []llm.Tool{{Name: "gh__search"}, {Name: "gh__issues"}},
+		Messages: []llm.Message{
+			llm.NewUserMessage(llm.NewTextBlock("Search mcp")),
+			llm.NewAssistantMessage(llm.NewTextBlock("Searching..."), llm.NewThinkingBlock("", "")),
+		},
+	}
+	config.Apply(&request)
+	params := llm.AnthropicParams(request)
+	if params.Tools[0].GetCacheControl().Type != "" || params.Tools[1].GetCacheControl().Type == "" {
+		t.Error("Expected only the last tool to be cached!")
+	}
+	if params.System[0].CacheControl.Type == "" {
+		t.Error("Expected the system prompt to be cached!")
+	}
+	lastMessage := params.Messages[len(params.Messages)-1]
+	if lastMessage.Content[0].GetCacheControl().Type == "" || params.Messages[0].Content[0].GetCacheControl().Type != "" {
+		t.Error("Expected only the last message to be cached!")
+	}
+
+	config.Cache = []string{}
+	config.Apply(&request)
+	params = llm.AnthropicParams(request)
+	if params.Tools[1].GetCacheControl().Type != "" || params.System[0].CacheControl.Type != "" {
+		t.Error("Expected nothing to be cached with an empty cache list!")
+	}
+
+	if _, err := LoadModelConfig(ModelConfig{Cache: []string{"everything"}}); err == nil {
+		t.Error("Expected an error for an unknown cache part!")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Notifications received before the TUI starts listening are kept up to this amount.
+const SERVER_NOTIFICATIONS_BUFFER = 64
+
+// A notification from an MCP server that the TUI must react to.
+type ServerNotification struct {
+	Config agent.MCPServerConfig
+	Client *client.Client
+	Method string
+}
+
+type ServerToolsChanged struct {
+	ServerName string
+	// Client the lists were refreshed with.
+	Client *client.Client
+	Tools  []agent.MCPTool
+}
+
+type ServerResourcesChanged struct {
+	ServerName string
+	// Client the lists were refreshed with.
+	Client    *client.Client
+	Resources []MCPResource
+	Templates []MCPResourceTemplate
+}
+
+type ServerPromptsChanged struct {
+	ServerName string
+	// Client the lists were refreshed with.
+	Client  *client.Client
+	Prompts []MCPPrompt
+}
+
+// Forwards a notification to the TUI if it's one the host handles.
+// Never blocks, since it's called from the goroutine reading the server responses.
+func NotifyServerChange(notifications chan<- ServerNotification, notification ServerNotification) {
+	switch notification.Method {
+	case mcp.MethodNotificationToolsListChanged,
+		mcp.MethodNotificationResourcesListChanged,
+		mcp.MethodNotificationPromptsListChanged:
+	default:
+		return
+	}
+
+	select {
+	case notifications <- notification:
+	default:
+		LOG.Printf("Dropping notification `%s` from `%s`, too many are pending!", notification.Method, notification.Config.Name)
+	}
+}
+
+func waitForServerNotification(notifications <-chan ServerNotification) tea.Cmd {
+	return func() tea.Msg {
+		return <-notifications
+	}
+}
+
+// Lists again whatever changed on the server that sent the notification.
+func refreshServerLists(ctx context.Context, notification ServerNotification) tea.Cmd {
+	ctx, cancelCtx := context.WithTimeout(ctx, 1*time.Minute)
+	return func() tea.Msg {
+		defer cancelCtx()
+
+		config := notification.Config
+		LOG.Printf("Refreshing `%s` after `%s`", config.Name, notification.Method)
+		switch notification.Method {
+		case mcp.MethodNotificationToolsListChanged:
+			tools, err := agent.ListServerTools(ctx, notification.Client, config, LOG)
+			if err != nil {
+				LOG.Printf("Failed to refresh tools of `%s`: %s", config.Name, err)
+				return nil
+			}
+			return ServerToolsChanged{ServerName: config.Name, Client: notification.Client, Tools: tools}
+
+		case mcp.MethodNotificationResourcesListChanged:
+			resources, templates, err := ListServerResources(ctx, notification.Client, config.Name)
+			if err != nil {
+				LOG.Printf("Failed to refresh resources of `%s`: %s", config.Name, err)
+				return nil
+			}
+			return ServerResourcesChanged{ServerName: config.Name, Client: notification.Client, Resources: resources, Templates: templates}
+
+		case mcp.MethodNotificationPromptsListChanged:
+			prompts, err := ListServerPrompts(ctx, notification.Client, config)
+			if err != nil {
+				LOG.Printf("Failed to refresh prompts of `%s`: %s", config.Name, err)
+				return nil
+			}
+			return ServerPromptsChanged{ServerName: config.Name, Client: notification.Client, Prompts: prompts}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// A prompt exposed by an MCP server, usable as a slash command.
+type MCPPrompt struct {
+	Client     *client.Client
+	ServerName string
+	// The slash command that triggers this prompt, `/<Prefix>:<prompt name>`.
+	Command string
+	Prompt  mcp.Prompt
+}
+
+// The arguments of a prompt the user is currently filling in, one at a time.
+type PromptForm struct {
+	Prompt MCPPrompt
+	Values map[string]string
+	// Index of the argument being asked for.
+	Current int
+}
+
+type PromptResponse struct {
+	Command string
+	Result  *mcp.GetPromptResult
+}
+
+// Builds the slash command of a prompt from a server with the given prefix.
+func PromptCommand(prefix string, promptName string) string {
+	return "/" + prefix + ":" + promptName
+}
+
+// Lists every prompt of an MCP server.
+func ListServerPrompts(
+	ctx context.Context,
+	mcpClient *client.Client,
+	config agent.MCPServerConfig,
+) ([]MCPPrompt, error) {
+	prefix := agent.ToolPrefix(config)
+	prompts := []MCPPrompt{}
+
+	var defaultCursor mcp.Cursor
+	var cursor mcp.Cursor
+	for {
+		request := mcp.ListPromptsRequest{}
+		request.Params.Cursor = cursor
+		svPrompts, err := mcpClient.ListPromptsByPage(ctx, request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prompts: %w", err)
+		}
+
+		for _, prompt := range svPrompts.Prompts {
+			prompts = append(prompts, MCPPrompt{
+				Client:     mcpClient,
+				ServerName: config.Name,
+				Command:    PromptCommand(prefix, prompt.Name),
+				Prompt:     prompt,
+			})
+		}
+
+		if svPrompts.NextCursor == defaultCursor {
+			break // No more pages
+		}
+		cursor = svPrompts.NextCursor
+	}
+	return prompts, nil
+}
+
+// Label shown on the textarea while asking for the current argument.
+func (form *PromptForm) Placeholder() string {
+	argument := form.Prompt.Prompt.Arguments[form.Current]
+	label := strings.Builder{}
+	label.WriteString(form.Prompt.Command)
+	label.WriteString(" ")
+	label.WriteString(argument.Name)
+	if argument.Required {
+		label.WriteString(" (required)")
+	}
+	if argument.Description != "" {
+		label.WriteString(": ")
+		label.WriteString(argument.Description)
+	}
+	return label.String()
+}
+
+// Stores the value of the current argument.
+// Returns true once every argument has been filled.
+func (form *PromptForm) Submit(value string) (bool, error) {
+	argument := form.Prompt.Prompt.Arguments[form.Current]
+	value = strings.TrimSpace(value)
+	if value == "" && argument.Required {
+		return false, fmt.Errorf("the argument `%s` is required", argument.Name)
+	}
+
+	if value != "" {
+		form.Values[argument.Name] = value
+	}
+	form.Current++
+	return form.Current >= len(form.Prompt.Prompt.Arguments), nil
+}
+
+func getPrompt(ctx context.Context, prompt MCPPrompt, arguments map[string]string) tea.Cmd {
+	ctx, cancelCtx := context.WithTimeout(ctx, 1*time.Minute)
+	return func() tea.Msg {
+		defer cancelCtx()
+
+		LOG.Printf("Getting prompt `%s` with: %#v", prompt.Command, arguments)
+		result, err := prompt.Client.GetPrompt(ctx, mcp.GetPromptRequest{
+			Params: mcp.GetPromptParams{
+				Name:      prompt.Prompt.Name,
+				Arguments: arguments,
+			},
+		})
+		if err != nil {
+			LOG.Printf("Failed to get prompt `%s`: %s", prompt.Command, err)
+			return fmt.Errorf("failed to get prompt `%s`: %w", prompt.Command, err)
+		}
+
+		return PromptResponse{
+			Command: prompt.Command,
+			Result:  result,
+		}
+	}
+}
+
+// Converts the messages of a prompt into conversation turns.
+// Consecutive messages with the same role are merged into a single turn.
+func PromptMessages(result *mcp.GetPromptResult) []llm.Message {
+	messages := []llm.Message{}
+	for _, promptMsg := range result.Messages {
+		role := llm.RoleUser
+		if promptMsg.Role == mcp.RoleAssistant {
+			role = llm.RoleAssistant
+		}
+
+		blocks := promptContentBlocks(promptMsg.Content, role)
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if len(messages) > 0 && messages[len(messages)-1].Role == role {
+			last := &messages[len(messages)-1]
+			last.Content = append(last.Content, blocks...)
+		} else {
+			messages = append(messages, llm.Message{
+				Role:    role,
+				Content: blocks,
+			})
+		}
+	}
+	return messages
+}
+
+// Assistant turns can only contain text, so anything else is described instead.
+func promptContentBlocks(content mcp.Content, role llm.Role) []llm.Block {
+	var blocks []llm.Block
+	switch content := content.(type) {
+	case mcp.TextContent:
+		return []llm.Block{llm.NewTextBlock(content.Text)}
+	case mcp.ImageContent:
+		if slices.Contains(agent.SUPPORTED_IMAGE_TYPES, content.MIMEType) {
+			blocks = []llm.Block{llm.NewImageBlock(content.MIMEType, content.Data)}
+		} else {
+			blocks = []llm.Block{llm.NewTextBlock(fmt.Sprintf("[Image with the unsupported format `%s`.]", content.MIMEType))}
+		}
+	case mcp.AudioContent:
+		return []llm.Block{llm.NewTextBlock(fmt.Sprintf("[%s audio that can't be listened to.]", content.MIMEType))}
+	case mcp.ResourceLink:
+		return []llm.Block{llm.NewTextBlock(agent.DescribeResourceLink(content))}
+	case mcp.EmbeddedResource:
+		blocks = ResourceBlocks([]mcp.ResourceContents{content.Resource})
+	default:
+		LOG.Printf("Unsupported block type for prompt message! %#v", content)
+		return nil
+	}
+
+	if role == llm.RoleAssistant {
+		for i, block := range blocks {
+			if block.Type != llm.BlockText {
+				blocks[i] = llm.NewTextBlock("[Attachment that can only be sent by the user.]")
+			}
+		}
+	}
+	return blocks
+}
+
+// Replaces every prompt of `serverName`.
+func (m *model) setServerPrompts(serverName string, prompts []MCPPrompt) {
+	m.prompts = slices.Concat(
+		slices.DeleteFunc(slices.Clone(m.prompts), func(prompt MCPPrompt) bool { return prompt.ServerName == serverName }),
+		prompts,
+	)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func Test_ListServerPromptsPaginates(t *testing.T) {
+	mcpServer := server.NewMCPServer("docs", "1.0.0", server.WithPaginationLimit(2), server.WithPromptCapabilities(false))
+	for i := range 5 {
+		mcpServer.AddPrompt(mcp.NewPrompt(fmt.Sprintf("summarize_%d", i)), func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+			return nil, nil
+		})
+	}
+	mcpClient, err := client.NewInProcessClient(mcpServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mcpClient.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	prompts, err := ListServerPrompts(context.Background(), mcpClient, agent.MCPServerConfig{Name: "docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 5 || prompts[4].Command != "/docs:summarize_4" {
+		t.Errorf("Expected every page of prompts, got %v", prompts)
+	}
+}
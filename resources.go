@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// A resource exposed by an MCP server.
+type MCPResource struct {
+	Client     *client.Client
+	ServerName string
+	Resource   mcp.Resource
+}
+
+// A resource template exposed by an MCP server.
+// Its URI must be expanded by the user before it can be read.
+type MCPResourceTemplate struct {
+	Client     *client.Client
+	ServerName string
+	Template   mcp.ResourceTemplate
+}
+
+// The contents of a resource, ready to be sent with the next user message.
+type ResourceAttachment struct {
+	URI    string
+	Blocks []llm.Block
+}
+
+// Lists every resource and resource template of an MCP server.
+func ListServerResources(
+	ctx context.Context,
+	mcpClient *client.Client,
+	serverName string,
+) ([]MCPResource, []MCPResourceTemplate, error) {
+	resources := []MCPResource{}
+	var defaultCursor mcp.Cursor
+	var cursor mcp.Cursor
+	for {
+		request := mcp.ListResourcesRequest{}
+		request.Params.Cursor = cursor
+		svResources, err := mcpClient.ListResourcesByPage(ctx, request)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list resources: %w", err)
+		}
+
+		for _, resource := range svResources.Resources {
+			resources = append(resources, MCPResource{
+				Client:     mcpClient,
+				ServerName: serverName,
+				Resource:   resource,
+			})
+		}
+
+		if svResources.NextCursor == defaultCursor {
+			break // No more pages
+		}
+		cursor = svResources.NextCursor
+	}
+
+	templates := []MCPResourceTemplate{}
+	cursor = defaultCursor
+	for {
+		request := mcp.ListResourceTemplatesRequest{}
+		request.Params.Cursor = cursor
+		svTemplates, err := mcpClient.ListResourceTemplatesByPage(ctx, request)
+		if err != nil {
+			// Templates are optional, some servers don't implement the method at all.
+			LOG.Printf("Failed to list resource templates of `%s`: %s", serverName, err)
+			return resources, nil, nil
+		}
+
+		for _, template := range svTemplates.ResourceTemplates {
+			templates = append(templates, MCPResourceTemplate{
+				Client:     mcpClient,
+				ServerName: serverName,
+				Template:   template,
+			})
+		}
+
+		if svTemplates.NextCursor == defaultCursor {
+			break // No more pages
+		}
+		cursor = svTemplates.NextCursor
+	}
+
+	return resources, templates, nil
+}
+
+func readResource(ctx context.Context, mcpClient *client.Client, uri string) tea.Cmd {
+	ctx, cancelCtx := context.WithTimeout(ctx, 1*time.Minute)
+	return func() tea.Msg {
+		defer cancelCtx()
+
+		LOG.Printf("Reading resource `%s`", uri)
+		resp, err := mcpClient.ReadResource(ctx, mcp.ReadResourceRequest{
+			Params: mcp.ReadResourceParams{
+				URI: uri,
+			},
+		})
+		if err != nil {
+			LOG.Printf("Failed to read resource `%s`: %s", uri, err)
+			return fmt.Errorf("failed to read resource `%s`: %w", uri, err)
+		}
+
+		return ResourceAttachment{
+			URI:    uri,
+			Blocks: ResourceBlocks(resp.Contents),
+		}
+	}
+}
+
+// Converts the contents of a resource into blocks for a user message.
+func ResourceBlocks(contents []mcp.ResourceContents) []llm.Block {
+	blocks := make([]llm.Block, 0, len(contents))
+	for _, content := range contents {
+		switch content := content.(type) {
+		case mcp.TextResourceContents:
+			blocks = append(blocks, textDocumentBlock(content.URI, content.Text))
+
+		case mcp.BlobResourceContents:
+			if slices.Contains(agent.SUPPORTED_IMAGE_TYPES, content.MIMEType) {
+				blocks = append(blocks, llm.NewImageBlock(content.MIMEType, content.Blob))
+			} else if content.MIMEType == "application/pdf" {
+				blocks = append(blocks, llm.NewDocumentBlock(content.MIMEType, content.Blob, content.URI))
+			} else if agent.IsTextMIMEType(content.MIMEType) {
+				text, err := base64.StdEncoding.DecodeString(content.Blob)
+				if err != nil {
+					blocks = append(blocks, llm.NewTextBlock(fmt.Sprintf("[Resource `%s` couldn't be decoded: %s]", content.URI, err)))
+				} else {
+					blocks = append(blocks, textDocumentBlock(content.URI, string(text)))
+				}
+			} else {
+				blocks = append(blocks, llm.NewTextBlock(fmt.Sprintf(
+					"[Resource `%s` contains binary data of type `%s` that can't be displayed.]",
+					content.URI,
+					content.MIMEType,
+				)))
+			}
+		}
+	}
+	return blocks
+}
+
+func textDocumentBlock(uri string, text string) llm.Block {
+	return llm.NewDocumentBlock("text/plain", text, uri)
+}
+
+// Renders the resources panel.
+func (m model) ResourcesView() string {
+	view := strings.Builder{}
+	view.WriteString("Resources (Enter to attach, Delete to clear attachments, F3 to close)\n\n")
+
+	if len(m.resources) == 0 && len(m.resourceTemplates) == 0 {
+		view.WriteString("No server exposes resources.\n")
+	}
+
+	for i, resource := range m.resources {
+		cursor := "  "
+		if i == m.resourceCursor {
+			cursor = "> "
+		}
+		view.WriteString(cursor)
+		view.WriteString(m.senderStyle.Render(resource.ServerName))
+		view.WriteString(" ")
+		view.WriteString(resource.Resource.Name)
+		view.WriteString(" (")
+		view.WriteString(resource.Resource.URI)
+		view.WriteString(")")
+		if resource.Resource.Description != "" {
+			view.WriteString(" - ")
+			view.WriteString(resource.Resource.Description)
+		}
+		view.WriteString("\n")
+	}
+
+	if len(m.resourceTemplates) > 0 {
+		view.WriteString("\nTemplates (select one and write the expanded URI on the textarea before pressing Enter)\n")
+		for i, template := range m.resourceTemplates {
+			cursor := "  "
+			if len(m.resources)+i == m.resourceCursor {
+				cursor = "> "
+			}
+			uriTemplate := ""
+			if template.Template.URITemplate != nil {
+				uriTemplate = template.Template.URITemplate.Raw()
+			}
+			view.WriteString(cursor)
+			view.WriteString(m.senderStyle.Render(template.ServerName))
+			view.WriteString(" ")
+			view.WriteString(template.Template.Name)
+			view.WriteString(" (")
+			view.WriteString(uriTemplate)
+			view.WriteString(")")
+			if template.Template.Description != "" {
+				view.WriteString(" - ")
+				view.WriteString(template.Template.Description)
+			}
+			view.WriteString("\n")
+		}
+	}
+
+	if len(m.attachments) > 0 {
+		view.WriteString("\nAttached to the next message:\n")
+		for _, attachment := range m.attachments {
+			view.WriteString("  ")
+			view.WriteString(attachment.URI)
+			view.WriteString("\n")
+		}
+	}
+
+	return view.String()
+}
+
+// Replaces every resource and template of `serverName`.
+func (m *model) setServerResources(serverName string, resources []MCPResource, templates []MCPResourceTemplate) {
+	m.resources = slices.Concat(
+		slices.DeleteFunc(slices.Clone(m.resources), func(resource MCPResource) bool { return resource.ServerName == serverName }),
+		resources,
+	)
+	m.resourceTemplates = slices.Concat(
+		slices.DeleteFunc(slices.Clone(m.resourceTemplates), func(template MCPResourceTemplate) bool { return template.ServerName == serverName }),
+		templates,
+	)
+	m.resourceCursor = max(min(m.resourceCursor, len(m.resources)+len(m.resourceTemplates)-1), 0)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func Test_ListServerResourcesPaginates(t *testing.T) {
+	LOG = log.New(io.Discard, "", 0)
+	mcpServer := server.NewMCPServer("docs", "1.0.0", server.WithPaginationLimit(2), server.WithResourceCapabilities(false, false))
+	for i := range 5 {
+		uri := fmt.Sprintf("docs://page/%d", i)
+		mcpServer.AddResource(mcp.NewResource(uri, uri), func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			return nil, nil
+		})
+		uriTemplate := fmt.Sprintf("docs://section/%d/{name}", i)
+		mcpServer.AddResourceTemplate(mcp.NewResourceTemplate(uriTemplate, uriTemplate), func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			return nil, nil
+		})
+	}
+	mcpClient, err := client.NewInProcessClient(mcpServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mcpClient.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	resources, templates, err := ListServerResources(context.Background(), mcpClient, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 5 || len(templates) != 5 {
+		t.Errorf("Expected every page of resources and templates, got %d and %d", len(resources), len(templates))
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	ant "github.com/anthropics/anthropic-sdk-go"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Matches complete model IDs like `claude-3-5-haiku-20241022` or `claude-sonnet-4-0`.
+var fullModelId = regexp.MustCompile(`^claude-[a-z0-9-]+-(\d{8}|latest|\d)$`)
+
+// A `sampling/createMessage` request waiting for the user's approval.
+type SamplingRequest struct {
+	ServerName string
+	Model      string
+	Params     mcp.CreateMessageParams
+	decision   chan<- bool
+}
+
+// Answers the request, it must only be called once.
+func (request SamplingRequest) Decide(approved bool) {
+	request.decision <- approved
+}
+
+// Handles the sampling requests of a single MCP server.
+// Every request must be approved by the user on the TUI before it reaches the LLM.
+type SamplingHandler struct {
+	ServerName string
+	Provider   llm.Provider
+	// The model of the chat, read on every request since `/model` changes it.
+	DefaultModel func() string
+	// Model hints name Claude models, so they're only used with Anthropic.
+	UseModelHints bool
+	MaxTokens     uint
+	// Temperatures asked by the server are lowered to this, since the provider rejects higher ones.
+	// `ANTHROPIC_MAX_TEMPERATURE` if 0.
+	MaxTemperature float64
+	Requests       chan<- SamplingRequest
+}
+
+func (handler *SamplingHandler) CreateMessage(ctx context.Context, request mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+	params := request.CreateMessageParams
+	model := handler.DefaultModel()
+	if handler.UseModelHints {
+		model = string(SamplingModel(params.ModelPreferences, ant.Model(model)))
+	}
+	LOG.Printf("Server `%s` requested sampling with `%s`", handler.ServerName, model)
+
+	decision := make(chan bool, 1)
+	select {
+	case handler.Requests <- SamplingRequest{
+		ServerName: handler.ServerName,
+		Model:      model,
+		Params:     params,
+		decision:   decision,
+	}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case approved := <-decision:
+		if !approved {
+			LOG.Printf("User rejected sampling request from `%s`", handler.ServerName)
+			return nil, errors.New("the user rejected the sampling request")
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	messages, err := SamplingMessages(params.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	maxTokens := int64(params.MaxTokens)
+	if maxTokens <= 0 {
+		maxTokens = int64(handler.MaxTokens)
+	}
+	llmRequest := llm.Request{
+		Model:         model,
+		System:        params.SystemPrompt,
+		Messages:      messages,
+		MaxTokens:     maxTokens,
+		StopSequences: params.StopSequences,
+	}
+	if temperature, found := agent.SamplingTemperature(ctx, params); found {
+		maxTemperature := handler.MaxTemperature
+		if maxTemperature == 0 {
+			maxTemperature = ANTHROPIC_MAX_TEMPERATURE
+		}
+		temperature = min(max(temperature, 0), maxTemperature)
+		llmRequest.Temperature = &temperature
+	}
+
+	response, err := handler.Provider.Stream(ctx, llmRequest, nil)
+	if err != nil {
+		LOG.Printf("Failed to sample for `%s`: %s", handler.ServerName, err)
+		return nil, err
+	}
+
+	text := strings.Builder{}
+	for _, block := range response.Message.Content {
+		if block.Type == llm.BlockText {
+			text.WriteString(block.Text)
+		}
+	}
+
+	return &mcp.CreateMessageResult{
+		SamplingMessage: mcp.SamplingMessage{
+			Role:    mcp.RoleAssistant,
+			Content: mcp.NewTextContent(text.String()),
+		},
+		Model:      response.Model,
+		StopReason: samplingStopReason(response.StopReason),
+	}, nil
+}
+
+// Chooses the model for a sampling request.
+//
+// Hints are checked in order, either a full Claude model ID or a family name like `haiku`.
+// Without a usable hint, servers that prioritize cost or speed over intelligence get Haiku.
+func SamplingModel(preferences *mcp.ModelPreferences, defaultModel ant.Model) ant.Model {
+	if preferences == nil {
+		return defaultModel
+	}
+
+	for _, hint := range preferences.Hints {
+		name := strings.ToLower(hint.Name)
+		if fullModelId.MatchString(name) {
+			return ant.Model(name)
+		}
+		for family, model := range MODEL_FAMILIES {
+			if strings.Contains(name, family) {
+				return model
+			}
+		}
+	}
+
+	if max(preferences.CostPriority, preferences.SpeedPriority) > preferences.IntelligencePriority {
+		return MODEL_FAMILIES["haiku"]
+	}
+	return defaultModel
+}
+
+// Converts the messages of a sampling request into LLM messages.
+func SamplingMessages(samplingMessages []mcp.SamplingMessage) ([]llm.Message, error) {
+	messages := make([]llm.Message, 0, len(samplingMessages))
+	for _, samplingMsg := range samplingMessages {
+		content, err := samplingContent(samplingMsg.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		role := llm.RoleUser
+		if samplingMsg.Role == mcp.RoleAssistant {
+			role = llm.RoleAssistant
+		}
+		messages = append(messages, llm.Message{
+			Role:    role,
+			Content: promptContentBlocks(content, role),
+		})
+	}
+	return messages, nil
+}
+
+// The content of sampling messages arrives as a generic JSON object.
+func samplingContent(content any) (mcp.Content, error) {
+	if content, ok := content.(mcp.Content); ok {
+		return content, nil
+	}
+
+	contentMap, ok := content.(map[string]any)
+	if !ok {
+		bytes, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(bytes, &contentMap); err != nil {
+			return nil, fmt.Errorf("invalid sampling message content: %w", err)
+		}
+	}
+	return mcp.ParseContent(contentMap)
+}
+
+func samplingStopReason(reason llm.StopReason) string {
+	switch reason {
+	case llm.StopReasonEndTurn:
+		return "endTurn"
+	case llm.StopReasonMaxTokens:
+		return "maxTokens"
+	case llm.StopReasonStopSequence:
+		return "stopSequence"
+	default:
+		return string(reason)
+	}
+}
+
+// Waits for the next sampling request from any server.
+func waitForSamplingRequest(requests <-chan SamplingRequest) tea.Cmd {
+	return func() tea.Msg {
+		return <-requests
+	}
+}
+
+// Renders the sampling request that's waiting for approval.
+func (m model) SamplingView() string {
+	if len(m.samplingQueue) == 0 {
+		return ""
+	}
+
+	request := m.samplingQueue[0]
+	view := strings.Builder{}
+	view.WriteString(m.errorStyle.Render(fmt.Sprintf("`%s` wants to use `%s`", request.ServerName, request.Model)))
+	view.WriteString("\nPress y to approve or n to reject.")
+	if len(m.samplingQueue) > 1 {
+		view.WriteString(fmt.Sprintf(" (%d more waiting)", len(m.samplingQueue)-1))
+	}
+	view.WriteString("\n\n")
+
+	if request.Params.SystemPrompt != "" {
+		view.WriteString(m.senderStyle.Render("System:"))
+		view.WriteString(" ")
+		view.WriteString(request.Params.SystemPrompt)
+		view.WriteString("\n")
+	}
+	for _, samplingMsg := range request.Params.Messages {
+		view.WriteString(m.senderStyle.Render(string(samplingMsg.Role) + ":"))
+		view.WriteString(" ")
+		content, err := samplingContent(samplingMsg.Content)
+		if text, ok := content.(mcp.TextContent); err == nil && ok {
+			view.WriteString(text.Text)
+		} else {
+			view.WriteString("(Can't display content on terminal!)")
+		}
+		view.WriteString("\n")
+	}
+	view.WriteString(fmt.Sprintf("\nMax tokens: %d", request.Params.MaxTokens))
+	return view.String()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	ant "github.com/anthropics/anthropic-sdk-go"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func Test_SamplingModel(t *testing.T) {
+	defaultModel := ant.ModelClaudeSonnet4_20250514
+
+	if model := SamplingModel(nil, defaultModel); model != defaultModel {
+		t.Errorf("Expected the default model without preferences, got `%s`", model)
+	}
+
+	model := SamplingModel(&mcp.ModelPreferences{
+		Hints: []mcp.ModelHint{{Name: "gpt-4o"}, {Name: "claude-3-5-haiku-20241022"}},
+	}, defaultModel)
+	if model != "claude-3-5-haiku-20241022" {
+		t.Errorf("Expected the full model ID from the hint, got `%s`", model)
+	}
+
+	model = SamplingModel(&mcp.ModelPreferences{
+		Hints: []mcp.ModelHint{{Name: "opus"}},
+	}, defaultModel)
+	if model != MODEL_FAMILIES["opus"] {
+		t.Errorf("Expected an opus model, got `%s`", model)
+	}
+
+	model = SamplingModel(&mcp.ModelPreferences{
+		SpeedPriority:        0.9,
+		IntelligencePriority: 0.2,
+	}, defaultModel)
+	if model != MODEL_FAMILIES["haiku"] {
+		t.Errorf("Expected a haiku model when speed is preferred, got `%s`", model)
+	}
+}
+
+func Test_SamplingHandlerUsesCurrentModel(t *testing.T) {
+	LOG = log.New(io.Discard, "", 0)
+	provider := &fakeProvider{responses: []llm.Response{
+		{Message: llm.NewAssistantMessage(llm.NewTextBlock("Summary")), StopReason: llm.StopReasonEndTurn},
+	}}
+	session := agent.NewSession(provider, agent.NewToolRegistry(), llm.Request{Model: "claude-sonnet-4-0"})
+	requests := make(chan SamplingRequest, 1)
+	handler := &SamplingHandler{
+		ServerName:   "docs",
+		Provider:     provider,
+		DefaultModel: func() string { return session.Defaults().Model },
+		Requests:     requests,
+	}
+
+	// Like `/model opus` after the server connected.
+	session.SetDefaults(llm.Request{Model: string(MODEL_FAMILIES["opus"])})
+	go func() {
+		(<-requests).Decide(true)
+	}()
+	_, err := handler.CreateMessage(context.Background(), mcp.CreateMessageRequest{
+		CreateMessageParams: mcp.CreateMessageParams{
+			Messages:  []mcp.SamplingMessage{{Role: mcp.RoleUser, Content: mcp.NewTextContent("Summarize")}},
+			MaxTokens: 100,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model := provider.requests[0].Model; model != string(MODEL_FAMILIES["opus"]) {
+		t.Errorf("Expected the current model of the chat, got `%s`", model)
+	}
+}
+
+func Test_SamplingHandlerClampsTemperature(t *testing.T) {
+	LOG = log.New(io.Discard, "", 0)
+	provider := &fakeProvider{responses: []llm.Response{
+		{Message: llm.NewAssistantMessage(llm.NewTextBlock("Summary")), StopReason: llm.StopReasonEndTurn},
+	}}
+	requests := make(chan SamplingRequest, 1)
+	handler := &SamplingHandler{
+		ServerName:     "docs",
+		Provider:       provider,
+		DefaultModel:   func() string { return "claude-sonnet-4-0" },
+		MaxTemperature: ANTHROPIC_MAX_TEMPERATURE,
+		Requests:       requests,
+	}
+
+	go func() {
+		(<-requests).Decide(true)
+	}()
+	_, err := handler.CreateMessage(context.Background(), mcp.CreateMessageRequest{
+		CreateMessageParams: mcp.CreateMessageParams{
+			Messages:    []mcp.SamplingMessage{{Role: mcp.RoleUser, Content: mcp.NewTextContent("Summarize")}},
+			MaxTokens:   100,
+			Temperature: 1.5,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if temperature := provider.requests[0].Temperature; temperature == nil || *temperature != ANTHROPIC_MAX_TEMPERATURE {
+		t.Errorf("Expected the temperature to be lowered to %g, got %v", ANTHROPIC_MAX_TEMPERATURE, temperature)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mark3labs/mcp-go/client"
+)
+
+// Attempts to connect to a server before giving up.
+const SERVER_CONNECTION_ATTEMPTS = 6
+
+// The state of a configured MCP server.
+type ServerStatus struct {
+	agent.ServerStatus
+	// Client of the server while it's ready, the lists refreshed with another one are outdated.
+	Client *client.Client
+	// Set while waiting for the user to authorize the host with OAuth.
+	AuthorizationURL string
+	Resources        int
+	Prompts          int
+}
+
+// What a server offers once it's ready.
+type ServerConnection struct {
+	Server    *agent.MCPServer
+	Resources []MCPResource
+	Templates []MCPResourceTemplate
+	Prompts   []MCPPrompt
+}
+
+// The state of a server changed.
+type ServerStatusChanged struct {
+	Status ServerStatus
+	// Set once the server is ready.
+	Connection *ServerConnection
+}
+
+// A server needs the user to authorize the host on the browser.
+type ServerAuthorization struct {
+	ServerName string
+	URL        string
+}
+
+// Opens the authorization URL of a server and tells the TUI to show it,
+// since printing it would break the TUI.
+func NewAuthorizationNotifier(ctx context.Context, authorizations chan<- ServerAuthorization) func(string, string) {
+	return func(serverName string, authURL string) {
+		if err := agent.OpenBrowser(authURL); err != nil {
+			LOG.Println("Failed to open the browser:", err)
+		}
+		select {
+		case authorizations <- ServerAuthorization{ServerName: serverName, URL: authURL}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func waitForServerAuthorization(authorizations <-chan ServerAuthorization) tea.Cmd {
+	return func() tea.Msg {
+		return <-authorizations
+	}
+}
+
+func waitForServerStatus(updates <-chan ServerStatusChanged) tea.Cmd {
+	return func() tea.Msg {
+		return <-updates
+	}
+}
+
+// Connects to every configured server in the background, trying each one up to `attempts` times,
+// and restarts them as their policy says. Their tools are kept on the session's registry
+// and their states are sent to `m.serverUpdates`.
+func (m model) startServers(attempts int) {
+	for _, status := range m.servers {
+		supervisor := agent.Supervisor{
+			Config:   status.Config,
+			Connect:  m.connectServer,
+			Tools:    m.session.Tools,
+			Attempts: attempts,
+			OnStatus: NewServerStatusSender(m.programCtx, m.serverUpdates),
+			Logger:   LOG,
+		}
+		m.waitGroup.Add(1)
+		go func() {
+			defer m.waitGroup.Done()
+			supervisor.Run(m.programCtx)
+		}()
+	}
+}
+
+// Sends the states of a supervised server to `updates`,
+// listing the resources and prompts of the server once it's ready.
+func NewServerStatusSender(ctx context.Context, updates chan<- ServerStatusChanged) func(agent.ServerStatus, *agent.MCPServer) {
+	return func(status agent.ServerStatus, server *agent.MCPServer) {
+		update := ServerStatusChanged{Status: ServerStatus{ServerStatus: status}}
+		if server != nil {
+			connection := LoadServer(ctx, server)
+			update.Connection = connection
+			update.Status.Client = server.Client
+			update.Status.Resources = len(connection.Resources) + len(connection.Templates)
+			update.Status.Prompts = len(connection.Prompts)
+		}
+
+		select {
+		case updates <- update:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Lists the resources and prompts of a server that just connected, its tools are listed by its supervisor.
+// Failing to list them isn't an error, since the LLM doesn't need them.
+func LoadServer(ctx context.Context, server *agent.MCPServer) *ServerConnection {
+	ctx, cancelCtx := context.WithTimeout(ctx, agent.SERVER_LIST_TIMEOUT)
+	defer cancelCtx()
+
+	config := server.Config
+	connection := &ServerConnection{Server: server}
+	capabilities := server.Capabilities
+	if capabilities.Resources != nil {
+		resources, templates, err := ListServerResources(ctx, server.Client, config.Name)
+		if err != nil {
+			LOG.Printf("Failed to obtain resources of `%s`: %s", config.Name, err)
+		}
+		connection.Resources = resources
+		connection.Templates = templates
+	}
+
+	if capabilities.Prompts != nil {
+		prompts, err := ListServerPrompts(ctx, server.Client, config)
+		if err != nil {
+			LOG.Printf("Failed to obtain prompts of `%s`: %s", config.Name, err)
+		}
+		connection.Prompts = prompts
+	}
+	return connection
+}
+
+// Tracks the new state of a server, adding or removing what it offers.
+// Its tools are already kept on the registry by its supervisor.
+func (m *model) setServerStatus(update ServerStatusChanged) {
+	status := update.Status
+	name := status.Config.Name
+	for i := range m.servers {
+		if m.servers[i].Config.Name == name {
+			// The supervisor doesn't know the authorization URL, which is shown until the server is ready or fails.
+			if status.State != agent.SERVER_STATES.Ready && status.State != agent.SERVER_STATES.Failed {
+				status.AuthorizationURL = m.servers[i].AuthorizationURL
+			}
+			m.servers[i] = status
+		}
+	}
+
+	if update.Connection != nil {
+		connection := update.Connection
+		m.setServerResources(name, connection.Resources, connection.Templates)
+		m.setServerPrompts(name, connection.Prompts)
+	} else if status.State != agent.SERVER_STATES.Ready {
+		m.setServerResources(name, nil, nil)
+		m.setServerPrompts(name, nil)
+	}
+}
+
+// Whether `mcpClient` is still the client of the server, which may have restarted
+// with another one while its lists were being refreshed.
+func (m model) isCurrentClient(serverName string, mcpClient *client.Client) bool {
+	for _, status := range m.servers {
+		if status.Config.Name == serverName {
+			return status.State == agent.SERVER_STATES.Ready && status.Client == mcpClient
+		}
+	}
+	return false
+}
+
+// Shows the authorization URL of a server until it's ready or fails.
+func (m *model) setServerAuthorization(authorization ServerAuthorization) {
+	for i := range m.servers {
+		if m.servers[i].Config.Name == authorization.ServerName {
+			m.servers[i].AuthorizationURL = authorization.URL
+		}
+	}
+}
+
+// Waits until no server is connecting or waiting for a retry.
+// Used without a TUI, which would otherwise receive the updates.
+func (m *model) waitForServers(ctx context.Context) {
+	for {
+		pending := false
+		for _, status := range m.servers {
+			pending = pending || status.Pending()
+		}
+		if !pending {
+			return
+		}
+
+		select {
+		case update := <-m.serverUpdates:
+			m.setServerStatus(update)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m model) ServerStateStyle(state agent.ServerState) lipgloss.Style {
+	switch state {
+	case agent.SERVER_STATES.Ready:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	case agent.SERVER_STATES.Connecting:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+	default:
+		return m.errorStyle
+	}
+}
+
+// Renders the state of every configured server.
+func (m model) ServersView() string {
+	if len(m.servers) == 0 {
+		return "No MCP servers configured!"
+	}
+
+	view := strings.Builder{}
+	view.WriteString("MCP servers:\n")
+	for _, status := range m.servers {
+		view.WriteString("\n")
+		view.WriteString(m.senderStyle.Render(status.Config.Name))
+		serverType := status.Config.Type
+		if status.Type != "" && status.Type != serverType {
+			serverType = serverType + " → " + status.Type
+		}
+		view.WriteString(fmt.Sprintf(" (%s) ", serverType))
+		view.WriteString(m.ServerStateStyle(status.State).Render(string(status.State)))
+
+		switch status.State {
+		case agent.SERVER_STATES.Ready:
+			view.WriteString(fmt.Sprintf(" - %d tools, %d resources, %d prompts", status.Tools, status.Resources, status.Prompts))
+			if status.Restarts > 0 {
+				view.WriteString(fmt.Sprintf(" (restarted %d times)", status.Restarts))
+			}
+		case agent.SERVER_STATES.Connecting:
+			if status.Attempts > 1 {
+				view.WriteString(fmt.Sprintf(" (attempt %d)", status.Attempts))
+			}
+		case agent.SERVER_STATES.Failed:
+			if !status.NextRetry.IsZero() {
+				view.WriteString(fmt.Sprintf(" - retrying in %s", time.Until(status.NextRetry).Round(time.Second)))
+			} else {
+				view.WriteString(fmt.Sprintf(" - gave up after %d attempts", status.Attempts))
+			}
+		case agent.SERVER_STATES.Disconnected:
+			if !status.NextRetry.IsZero() {
+				view.WriteString(fmt.Sprintf(" - restarting in %s", time.Until(status.NextRetry).Round(time.Second)))
+			} else {
+				view.WriteString(" - not restarted")
+			}
+		}
+		view.WriteString("\n")
+
+		if status.State == agent.SERVER_STATES.Connecting && status.AuthorizationURL != "" {
+			view.WriteString("  Authorize the access on your browser: ")
+			view.WriteString(status.AuthorizationURL)
+			view.WriteString("\n")
+		}
+		if status.Err != nil && status.State != agent.SERVER_STATES.Ready {
+			view.WriteString("  ")
+			view.WriteString(m.errorStyle.Render(status.Err.Error()))
+			view.WriteString("\n")
+		}
+	}
+	return view.String()
+}
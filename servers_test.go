@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func Test_StartServers(t *testing.T) {
+	LOG = log.New(io.Discard, "", 0)
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	attempts := map[string]int{}
+	mutex := sync.Mutex{}
+	m := model{
+		programCtx:    ctx,
+		waitGroup:     &sync.WaitGroup{},
+		session:       agent.NewSession(&fakeProvider{}, agent.NewToolRegistry(), llm.Request{}),
+		serverUpdates: make(chan ServerStatusChanged, SERVER_NOTIFICATIONS_BUFFER),
+		servers: []ServerStatus{
+			{ServerStatus: agent.ServerStatus{Config: agent.MCPServerConfig{Name: "flaky", Type: agent.MCP_SERVERS_TYPE.Stdio}, State: agent.SERVER_STATES.Connecting}},
+			{ServerStatus: agent.ServerStatus{Config: agent.MCPServerConfig{Name: "down", Type: agent.MCP_SERVERS_TYPE.Stdio}, State: agent.SERVER_STATES.Connecting}},
+		},
+		connectServer: func(ctx context.Context, config agent.MCPServerConfig) (*agent.MCPServer, error) {
+			mutex.Lock()
+			attempts[config.Name]++
+			attempt := attempts[config.Name]
+			mutex.Unlock()
+
+			if config.Name == "down" || attempt == 1 {
+				return nil, errors.New("connection refused")
+			}
+			mcpServer := server.NewMCPServer("test", "1.0.0")
+			mcpServer.AddTool(mcp.NewTool("search"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return mcp.NewToolResultText("found"), nil
+			})
+			mcpClient, err := client.NewInProcessClient(mcpServer)
+			if err != nil {
+				return nil, err
+			}
+			initResult, err := mcpClient.Initialize(ctx, mcp.InitializeRequest{})
+			if err != nil {
+				return nil, err
+			}
+			connected := agent.NewMCPServer(config, config.Type, mcpClient)
+			connected.Capabilities = initResult.Capabilities
+			return connected, nil
+		},
+	}
+
+	m.startServers(2)
+	m.waitForServers(ctx)
+	// The tools are removed once the servers stop.
+	tools := m.session.Tools.Definitions()
+	cancelCtx()
+	m.waitGroup.Wait()
+
+	flaky, down := m.servers[0], m.servers[1]
+	if flaky.State != agent.SERVER_STATES.Ready || flaky.Attempts != 2 || flaky.Tools != 1 {
+		t.Errorf("Expected `flaky` to be ready on the second attempt: %+v", flaky)
+	}
+	if down.State != agent.SERVER_STATES.Failed || down.Attempts != 2 || down.Err == nil || down.Pending() {
+		t.Errorf("Expected `down` to give up after two attempts: %+v", down)
+	}
+
+	if len(tools) != 1 || tools[0].Name != "flaky__search" {
+		t.Errorf("Expected only the tools of `flaky`: %v", tools)
+	}
+}
+
+func Test_IsCurrentClient(t *testing.T) {
+	restarted, err := client.NewInProcessClient(server.NewMCPServer("test", "1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := client.NewInProcessClient(server.NewMCPServer("test", "1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ready := agent.ServerStatus{Config: agent.MCPServerConfig{Name: "ready"}, State: agent.SERVER_STATES.Ready}
+	restarting := agent.ServerStatus{Config: agent.MCPServerConfig{Name: "restarting"}, State: agent.SERVER_STATES.Disconnected}
+	m := model{servers: []ServerStatus{
+		{ServerStatus: ready, Client: restarted},
+		{ServerStatus: restarting},
+	}}
+
+	if !m.isCurrentClient("ready", restarted) {
+		t.Error("Expected the lists refreshed with the current client to be applied")
+	}
+	if m.isCurrentClient("ready", previous) {
+		t.Error("Expected the lists refreshed before the restart to be dropped")
+	}
+	if m.isCurrentClient("restarting", previous) {
+		t.Error("Expected the lists of a disconnected server to be dropped")
+	}
+}
+
+func Test_SetServerStatusKeepsAuthorization(t *testing.T) {
+	config := agent.MCPServerConfig{Name: "oauth"}
+	m := model{servers: []ServerStatus{{ServerStatus: agent.ServerStatus{Config: config, State: agent.SERVER_STATES.Connecting}}}}
+	m.setServerAuthorization(ServerAuthorization{ServerName: "oauth", URL: "https://example.com/authorize"})
+
+	m.setServerStatus(ServerStatusChanged{Status: ServerStatus{ServerStatus: agent.ServerStatus{Config: config, State: agent.SERVER_STATES.Connecting, Attempts: 2}}})
+	if m.servers[0].AuthorizationURL == "" || m.servers[0].Attempts != 2 {
+		t.Errorf("Expected the authorization URL to be kept while connecting: %+v", m.servers[0])
+	}
+
+	m.setServerStatus(ServerStatusChanged{Status: ServerStatus{ServerStatus: agent.ServerStatus{Config: config, State: agent.SERVER_STATES.Failed}}})
+	if m.servers[0].AuthorizationURL != "" {
+		t.Errorf("Expected the authorization URL to be removed once the server failed: %+v", m.servers[0])
+	}
+}
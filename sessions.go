@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+const DEFAULT_SESSIONS_DIR = "sessions"
+
+const SESSION_FILE_EXTENSION = ".jsonl"
+
+// Max length of the title shown when listing sessions.
+const SESSION_TITLE_LENGTH = 60
+
+// A single line of a session file.
+type SessionEntry struct {
+	Time time.Time `json:"time"`
+	// Marks where the conversation was compacted, the messages before it were replaced by the ones after it.
+	Compacted bool `json:"compacted,omitempty"`
+	llm.Message
+}
+
+// Summary of a stored session.
+type SessionInfo struct {
+	Id       string
+	Updated  time.Time
+	Messages int
+	// Text of the first user message.
+	Title string
+}
+
+// Stores every session as a JSON lines file inside `Dir`,
+// one line per message (including tool uses and results).
+type SessionStore struct {
+	Dir string
+}
+
+// Creates a unique ID for a new session, sortable by creation time.
+func NewSessionId() string {
+	suffix := make([]byte, 2)
+	_, _ = rand.Read(suffix)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+func (store SessionStore) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid session ID `%s`", id)
+	}
+	return filepath.Join(store.Dir, id+SESSION_FILE_EXTENSION), nil
+}
+
+// Appends messages to a session, creating it if it doesn't exist.
+func (store SessionStore) Append(id string, messages ...llm.Message) error {
+	return store.append(id, false, messages)
+}
+
+// Replaces the conversation of a session with its compacted `messages`.
+// The previous messages stay on the file, but they're skipped when loading it.
+func (store SessionStore) AppendCompacted(id string, messages ...llm.Message) error {
+	return store.append(id, true, messages)
+}
+
+func (store SessionStore) append(id string, compacted bool, messages []llm.Message) error {
+	path, err := store.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(store.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open session `%s`: %w", id, err)
+	}
+	defer file.Close()
+
+	// Starts a new line if the last write was cut in half by a crash.
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		lastByte := make([]byte, 1)
+		if reader, err := os.Open(path); err == nil {
+			_, err = reader.ReadAt(lastByte, info.Size()-1)
+			reader.Close()
+			if err == nil && lastByte[0] != '\n' {
+				file.WriteString("\n")
+			}
+		}
+	}
+
+	now := time.Now()
+	encoder := json.NewEncoder(file)
+	if compacted {
+		if err := encoder.Encode(SessionEntry{Time: now, Compacted: true}); err != nil {
+			return fmt.Errorf("failed to save session `%s`: %w", id, err)
+		}
+	}
+	for _, msg := range messages {
+		if err := encoder.Encode(SessionEntry{Time: now, Message: msg}); err != nil {
+			return fmt.Errorf("failed to save session `%s`: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Reads every message of a session.
+func (store SessionStore) Load(id string) ([]llm.Message, error) {
+	path, err := store.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("session `%s` doesn't exist", id)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open session `%s`: %w", id, err)
+	}
+	defer file.Close()
+
+	messages := []llm.Message{}
+	scanner := bufio.NewScanner(file)
+	// Tool results with images can make really long lines.
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var entry SessionEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// The last line may be incomplete if the host crashed while writing it.
+			LOG.Printf("Skipping line %d of session `%s`: %s", line, id, err)
+			continue
+		}
+		if entry.Compacted {
+			messages = messages[:0]
+			continue
+		}
+		messages = append(messages, entry.Message)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read session `%s`: %w", id, err)
+	}
+
+	return messages, nil
+}
+
+// Lists every stored session, the most recently updated first.
+func (store SessionStore) List() ([]SessionInfo, error) {
+	entries, err := os.ReadDir(store.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := []SessionInfo{}
+	for _, entry := range entries {
+		id, found := strings.CutSuffix(entry.Name(), SESSION_FILE_EXTENSION)
+		if entry.IsDir() || !found {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		messages, err := store.Load(id)
+		if err != nil {
+			LOG.Printf("Skipping session `%s`: %s", id, err)
+			continue
+		}
+
+		sessions = append(sessions, SessionInfo{
+			Id:       id,
+			Updated:  info.ModTime(),
+			Messages: len(messages),
+			Title:    sessionTitle(messages),
+		})
+	}
+
+	slices.SortFunc(sessions, func(a, b SessionInfo) int {
+		return b.Updated.Compare(a.Updated)
+	})
+	return sessions, nil
+}
+
+func (store SessionStore) Delete(id string) error {
+	path, err := store.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("session `%s` doesn't exist", id)
+	}
+	return err
+}
+
+// Copies a session into a new one, returning the ID of the copy.
+func (store SessionStore) Fork(id string) (string, error) {
+	path, err := store.path(id)
+	if err != nil {
+		return "", err
+	}
+
+	source, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("session `%s` doesn't exist", id)
+	} else if err != nil {
+		return "", err
+	}
+	defer source.Close()
+
+	forkId := NewSessionId()
+	forkPath, err := store.path(forkId)
+	if err != nil {
+		return "", err
+	}
+	fork, err := os.OpenFile(forkPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create session `%s`: %w", forkId, err)
+	}
+	defer fork.Close()
+
+	if _, err := io.Copy(fork, source); err != nil {
+		return "", fmt.Errorf("failed to fork session `%s`: %w", id, err)
+	}
+	return forkId, nil
+}
+
+func sessionTitle(messages []llm.Message) string {
+	for _, msg := range messages {
+		if msg.Role != llm.RoleUser {
+			continue
+		}
+		for _, block := range msg.Content {
+			if block.Type != llm.BlockText {
+				continue
+			}
+
+			title := strings.Join(strings.Fields(block.Text), " ")
+			if len(title) > SESSION_TITLE_LENGTH {
+				title = title[:SESSION_TITLE_LENGTH-3] + "..."
+			}
+			return title
+		}
+	}
+	return "(No text)"
+}
+
+// Saves every message of the conversation that's not saved yet.
+// The response currently being generated is saved once it finishes.
+func (m *model) saveSession() {
+	messages := m.session.Messages()
+	if len(messages) <= m.savedMessages {
+		return
+	}
+
+	if err := m.sessionStore.Append(m.sessionId, messages[m.savedMessages:]...); err != nil {
+		LOG.Println("Failed to save session:", err)
+		m.err = err
+		return
+	}
+	m.savedMessages = len(messages)
+}
+
+// Saves the whole conversation again after the session compacted it.
+func (m *model) saveCompactedSession() {
+	messages := m.session.Messages()
+	if err := m.sessionStore.AppendCompacted(m.sessionId, messages...); err != nil {
+		LOG.Println("Failed to save compacted session:", err)
+		m.err = err
+		return
+	}
+	m.savedMessages = len(messages)
+}
+
+// Replaces the current conversation with the one stored on a session.
+func (m *model) resumeSession(id string) error {
+	messages, err := m.sessionStore.Load(id)
+	if err != nil {
+		return err
+	}
+
+	if err := m.session.SetMessages(messages); err != nil {
+		return err
+	}
+
+	LOG.Printf("Resuming session `%s` with %d messages", id, len(messages))
+	m.sessionId = id
+	m.savedMessages = len(messages)
+	m.syncMessages()
+	return nil
+}
+
+// Renders the list of stored sessions.
+func (m model) SessionsView() string {
+	sessions, err := m.sessionStore.List()
+	if err != nil {
+		return m.errorStyle.Render(err.Error())
+	}
+
+	view := strings.Builder{}
+	view.WriteString("Current session: " + m.sessionId + "\n\n")
+	if len(sessions) == 0 {
+		view.WriteString("No sessions saved yet.\n")
+	}
+	for _, session := range sessions {
+		view.WriteString(m.senderStyle.Render(session.Id))
+		view.WriteString(fmt.Sprintf(" %s (%d messages) %s\n", session.Updated.Format(time.DateTime), session.Messages, session.Title))
+	}
+	view.WriteString("\n/resume <id>: Continue a session\n/fork [id]: Continue a copy of a session, the current one by default\n/delete <id>: Delete a session\n")
+	return view.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+func Test_SessionStore(t *testing.T) {
+	LOG = log.New(io.Discard, "", 0)
+	store := SessionStore{Dir: filepath.Join(t.TempDir(), "sessions")}
+
+	sessions, err := store.List()
+	if err != nil || len(sessions) != 0 {
+		t.Fatalf("Expected no sessions before saving any, got %v %s", sessions, err)
+	}
+
+	id := NewSessionId()
+	err = store.Append(id,
+		llm.NewUserMessage(llm.NewTextBlock("Search  for\nmcp")),
+		llm.NewAssistantMessage(llm.NewToolUseBlock("toolu_01", "gh__search", json.RawMessage(`{"query":"mcp"}`))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Append(id, llm.NewUserMessage(llm.NewToolResultBlock("toolu_01", []llm.Block{llm.NewTextBlock("no results")}, true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A line cut in half by a crash shouldn't lose the rest of the session.
+	file, _ := os.OpenFile(filepath.Join(store.Dir, id+SESSION_FILE_EXTENSION), os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(`{"time":"2026-`)
+	file.Close()
+
+	err = store.Append(id, llm.NewAssistantMessage(llm.NewTextBlock("Nothing found.")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := store.Load(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 messages but got %d", len(messages))
+	}
+	if string(messages[1].Content[0].Input) != `{"query":"mcp"}` {
+		t.Errorf("Tool uses should be kept, got %#v", messages[1].Content[0])
+	}
+	if result := messages[2].Content[0]; result.ToolUseId != "toolu_01" || !result.IsError {
+		t.Errorf("Tool results should be kept, got %#v", result)
+	}
+
+	forkId, err := store.Fork(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forked, err := store.Load(forkId)
+	if err != nil || len(forked) != 4 {
+		t.Errorf("Expected the fork to have 4 messages, got %d %s", len(forked), err)
+	}
+
+	sessions, err = store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].Title != "Search for mcp" {
+		t.Errorf("Expected both sessions to be listed, got %#v", sessions)
+	}
+
+	if err := store.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(id); err == nil {
+		t.Error("Deleted sessions shouldn't be loaded!")
+	}
+	if _, err := store.Load("../config"); err == nil {
+		t.Error("Session IDs can't be paths!")
+	}
+}
+
+func Test_SessionStoreCompacted(t *testing.T) {
+	store := SessionStore{Dir: t.TempDir()}
+	id := NewSessionId()
+
+	err := store.Append(id, llm.NewUserMessage(llm.NewTextBlock("Search mcp")), llm.NewAssistantMessage(llm.NewTextBlock("Found it.")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AppendCompacted(id, llm.NewUserMessage(llm.NewTextBlock("Summary")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Append(id, llm.NewAssistantMessage(llm.NewTextBlock("Continuing.")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := store.Load(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Content[0].Text != "Summary" || messages[1].Content[0].Text != "Continuing." {
+		t.Errorf("Expected only the compacted conversation, got %#v", messages)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/agent"
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A tool call waiting for the user's approval.
+type ToolApprovalRequest struct {
+	ToolUse  llm.Block
+	Tool     agent.MCPTool
+	ctx      context.Context
+	decision chan<- agent.ToolDecision
+}
+
+// The run asking for approval was cancelled, so nobody is waiting for the decision.
+func (request ToolApprovalRequest) Cancelled() bool {
+	return request.ctx.Err() != nil
+}
+
+// Answers the request, it must only be called once.
+func (request ToolApprovalRequest) Decide(decision agent.ToolDecision) {
+	request.decision <- decision
+}
+
+// Sends every tool call that needs approval to the TUI and waits for the user's decision.
+func NewToolApprover(requests chan<- ToolApprovalRequest) agent.ToolApprover {
+	return func(ctx context.Context, toolUse llm.Block, tool agent.MCPTool) agent.ToolDecision {
+		decision := make(chan agent.ToolDecision, 1)
+		select {
+		case requests <- ToolApprovalRequest{ToolUse: toolUse, Tool: tool, ctx: ctx, decision: decision}:
+		case <-ctx.Done():
+			return agent.TOOL_DECISIONS.Deny
+		}
+
+		select {
+		case answer := <-decision:
+			return answer
+		case <-ctx.Done():
+			return agent.TOOL_DECISIONS.Deny
+		}
+	}
+}
+
+// Waits for the next tool call that needs approval.
+func waitForToolApproval(requests <-chan ToolApprovalRequest) tea.Cmd {
+	return func() tea.Msg {
+		return <-requests
+	}
+}
+
+// Keys used to answer a tool approval request.
+var TOOL_DECISION_KEYS = map[string]agent.ToolDecision{
+	"y": agent.TOOL_DECISIONS.AllowOnce,
+	"a": agent.TOOL_DECISIONS.AllowAlways,
+	"n": agent.TOOL_DECISIONS.Deny,
+}
+
+// Renders the tool call that's waiting for approval.
+func (m model) ToolApprovalView() string {
+	if len(m.approvalQueue) == 0 {
+		return ""
+	}
+
+	request := m.approvalQueue[0]
+	view := strings.Builder{}
+	view.WriteString(m.errorStyle.Render(fmt.Sprintf("The LLM wants to use `%s` of `%s`", request.Tool.Name, request.Tool.ServerName)))
+	view.WriteString("\nPress y to allow once, a to always allow or n to deny.")
+	if len(m.approvalQueue) > 1 {
+		view.WriteString(fmt.Sprintf(" (%d more waiting)", len(m.approvalQueue)-1))
+	}
+	view.WriteString("\n\n")
+
+	if request.Tool.Definition.Description != "" {
+		view.WriteString(request.Tool.Definition.Description)
+		view.WriteString("\n\n")
+	}
+
+	view.WriteString(m.senderStyle.Render("Arguments:"))
+	view.WriteString("\n")
+	arguments := bytes.Buffer{}
+	if len(request.ToolUse.Input) == 0 {
+		view.WriteString("(None)")
+	} else if err := json.Indent(&arguments, request.ToolUse.Input, "", "  "); err != nil {
+		view.Write(request.ToolUse.Input)
+	} else {
+		view.Write(arguments.Bytes())
+	}
+	return view.String()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Prices in USD per million tokens, along with the size of the model's context window.
+// A zero `ContextWindow` means it's unknown.
+type ModelPricing struct {
+	Input         float64
+	Output        float64
+	CacheWrite    float64
+	CacheRead     float64
+	ContextWindow int64
+}
+
+// Prices of the Claude models, the `[Pricing]` section of the config is added to these.
+var DEFAULT_PRICING = map[string]ModelPricing{
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheWrite: 0.3, CacheRead: 0.03, ContextWindow: 200_000},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4, CacheWrite: 1, CacheRead: 0.08, ContextWindow: 200_000},
+	"claude-haiku-4-5":  {Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.1, ContextWindow: 200_000},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3, ContextWindow: 200_000},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3, ContextWindow: 200_000},
+	"claude-opus-4":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5, ContextWindow: 200_000},
+}
+
+// Warns the user once the context is this full.
+const CONTEXT_WARNING_RATIO = 0.8
+
+const STATUS_BAR_HEIGHT = 1
+
+// Adds the prices of the config to the default ones, replacing them if a model is on both.
+func LoadPricing(custom map[string]ModelPricing) map[string]ModelPricing {
+	pricing := make(map[string]ModelPricing, len(DEFAULT_PRICING)+len(custom))
+	for model, prices := range DEFAULT_PRICING {
+		pricing[model] = prices
+	}
+	for model, prices := range custom {
+		pricing[model] = prices
+	}
+	return pricing
+}
+
+// Finds the prices of a model by its ID or, since responses include the full ID,
+// by the longest model on the table that's a prefix of it (`claude-sonnet-4` for `claude-sonnet-4-20250514`).
+func FindPricing(table map[string]ModelPricing, model string) (ModelPricing, bool) {
+	if prices, found := table[model]; found {
+		return prices, true
+	}
+
+	bestMatch := ""
+	for name := range table {
+		if strings.HasPrefix(model, name) && len(name) > len(bestMatch) {
+			bestMatch = name
+		}
+	}
+	if bestMatch == "" {
+		return ModelPricing{}, false
+	}
+	return table[bestMatch], true
+}
+
+// Estimated cost in USD of the tokens used.
+func (pricing ModelPricing) Cost(usage llm.Usage) float64 {
+	cost := float64(usage.InputTokens)*pricing.Input +
+		float64(usage.OutputTokens)*pricing.Output +
+		float64(usage.CacheCreationInputTokens)*pricing.CacheWrite +
+		float64(usage.CacheReadInputTokens)*pricing.CacheRead
+	return cost / 1_000_000
+}
+
+// Tokens used by the responses of this session.
+type UsageTracker struct {
+	Pricing map[string]ModelPricing
+	// Model of the last response.
+	Model string
+	Last  llm.Usage
+	Total llm.Usage
+	Turns int
+	// Estimated cost of every response with known prices.
+	Cost float64
+	// Some response used a model without known prices, so `Cost` is lower than the real one.
+	MissingPrices bool
+}
+
+func (tracker *UsageTracker) Add(model string, usage llm.Usage) {
+	tracker.Model = model
+	tracker.Last = usage
+	tracker.Total.Add(usage)
+	tracker.Turns++
+
+	if prices, found := FindPricing(tracker.Pricing, model); found {
+		tracker.Cost += prices.Cost(usage)
+	} else {
+		tracker.MissingPrices = true
+	}
+}
+
+// How full the context window was after the last response, 0 if the window is unknown.
+func (tracker UsageTracker) ContextRatio() float64 {
+	prices, found := FindPricing(tracker.Pricing, tracker.Model)
+	if !found || prices.ContextWindow == 0 {
+		return 0
+	}
+	return float64(tracker.Last.TotalTokens()) / float64(prices.ContextWindow)
+}
+
+// Fraction of the input tokens read from the cache.
+func CacheHitRatio(usage llm.Usage) float64 {
+	input := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+	if input == 0 {
+		return 0
+	}
+	return float64(usage.CacheReadInputTokens) / float64(input)
+}
+
+// Formats an amount of tokens like `1.5k`.
+func formatTokens(tokens int64) string {
+	switch {
+	case tokens >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(tokens)/1_000_000)
+	case tokens >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(tokens)/1_000)
+	default:
+		return fmt.Sprint(tokens)
+	}
+}
+
+func formatUsage(usage llm.Usage) string {
+	text := formatTokens(usage.InputTokens) + " in, " + formatTokens(usage.OutputTokens) + " out"
+	if usage.CacheReadInputTokens > 0 || usage.CacheCreationInputTokens > 0 {
+		text += fmt.Sprintf(
+			" (cache %s read, %s written, %.0f%% hit)",
+			formatTokens(usage.CacheReadInputTokens),
+			formatTokens(usage.CacheCreationInputTokens),
+			CacheHitRatio(usage)*100,
+		)
+	}
+	return text
+}
+
+// Renders the tokens used by the last response and the whole session.
+func (m model) StatusBarView() string {
+	usage := m.usage
+	if usage.Turns == 0 {
+		return m.senderStyle.MaxWidth(m.viewport.Width).Render("No tokens used yet")
+	}
+
+	cost := fmt.Sprintf("$%.4f", usage.Cost)
+	if usage.MissingPrices {
+		cost = "at least " + cost
+	}
+	status := fmt.Sprintf("Last: %s | Session: %s | %s", formatUsage(usage.Last), formatUsage(usage.Total), cost)
+
+	ratio := usage.ContextRatio()
+	if ratio > 0 {
+		status += fmt.Sprintf(" | Context %.0f%%", ratio*100)
+	}
+	status = m.senderStyle.Render(status)
+	// Shown first so it's never cut off on small terminals.
+	if ratio >= CONTEXT_WARNING_RATIO {
+		status = m.errorStyle.Render("Context almost full!") + " " + status
+	}
+	return lipgloss.NewStyle().MaxWidth(m.viewport.Width).Render(status)
+}
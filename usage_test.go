@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ElrohirGT/Redes_Proyecto1/llm"
+)
+
+func Test_FindPricing(t *testing.T) {
+	pricing := LoadPricing(map[string]ModelPricing{
+		"claude-sonnet-4-5": {Input: 4, Output: 20},
+		"llama3":            {ContextWindow: 8192},
+	})
+
+	cases := []struct {
+		model    string
+		found    bool
+		expected float64
+	}{
+		{"claude-sonnet-4-20250514", true, 3},
+		{"claude-sonnet-4-5-20250929", true, 4},
+		{"claude-opus-4-1-20250805", true, 15},
+		{"llama3", true, 0},
+		{"gpt-4o", false, 0},
+	}
+	for _, c := range cases {
+		prices, found := FindPricing(pricing, c.model)
+		if found != c.found || prices.Input != c.expected {
+			t.Errorf("Expected input price %v (found: %v) for `%s` but got %v (found: %v)", c.expected, c.found, c.model, prices.Input, found)
+		}
+	}
+}
+
+func Test_UsageTracker(t *testing.T) {
+	tracker := UsageTracker{Pricing: LoadPricing(nil)}
+	tracker.Add("claude-sonnet-4-20250514", llm.Usage{InputTokens: 100_000, OutputTokens: 10_000, CacheReadInputTokens: 60_000})
+	tracker.Add("claude-sonnet-4-20250514", llm.Usage{InputTokens: 1_000, OutputTokens: 500})
+
+	if tracker.Turns != 2 || tracker.Total.InputTokens != 101_000 || tracker.Total.OutputTokens != 10_500 {
+		t.Errorf("Unexpected totals: %+v", tracker)
+	}
+	// 0.3 + 0.15 + 0.018 for the first turn, 0.003 + 0.0075 for the second.
+	if math.Abs(tracker.Cost-0.4785) > 1e-9 || tracker.MissingPrices {
+		t.Errorf("Unexpected cost: %v", tracker.Cost)
+	}
+	if ratio := tracker.ContextRatio(); math.Abs(ratio-0.0075) > 1e-9 {
+		t.Errorf("The context ratio should only use the last turn, got %v", ratio)
+	}
+
+	tracker.Add("unknown-model", llm.Usage{InputTokens: 10})
+	if !tracker.MissingPrices || tracker.ContextRatio() != 0 {
+		t.Error("Expected unknown models to be reported as missing prices!")
+	}
+}